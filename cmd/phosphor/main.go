@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/brporter/phosphor/internal/cli"
 	"github.com/spf13/cobra"
@@ -41,6 +42,7 @@ Typical setup on a machine you want to reach:
 	// --- login ---
 	var provider string
 	var useDeviceCode bool
+	var loginTimeout time.Duration
 	loginCmd := &cobra.Command{
 		Use:   "login",
 		Short: "Authenticate with an identity provider",
@@ -49,11 +51,12 @@ Typical setup on a machine you want to reach:
 			if err != nil {
 				return err
 			}
-			return cli.Login(context.Background(), provider, relay, useDeviceCode)
+			return cli.Login(context.Background(), provider, relay, useDeviceCode, loginTimeout)
 		},
 	}
 	loginCmd.Flags().StringVar(&provider, "provider", "microsoft", "OIDC provider (apple, microsoft, google)")
 	loginCmd.Flags().BoolVar(&useDeviceCode, "device-code", false, "Use device code flow instead of browser (Microsoft/Google only)")
+	loginCmd.Flags().DurationVar(&loginTimeout, "login-timeout", 0, "How long to wait for the browser login (default: the relay's login session lifetime)")
 
 	// --- logout ---
 	logoutCmd := &cobra.Command{
@@ -72,6 +75,7 @@ Typical setup on a machine you want to reach:
 	var enrollName string
 	var enrollAPIKey string
 	var enrollSSHDAddr string
+	var enrollLoginTimeout time.Duration
 	enrollCmd := &cobra.Command{
 		Use:   "enroll",
 		Short: "Register this machine with the relay for SSH tunnel access",
@@ -82,10 +86,11 @@ Typical setup on a machine you want to reach:
 				return err
 			}
 			cfg, err := cli.Enroll(context.Background(), cli.EnrollOptions{
-				RelayURL: relay,
-				Name:     enrollName,
-				APIKey:   enrollAPIKey,
-				SSHDAddr: enrollSSHDAddr,
+				RelayURL:     relay,
+				Name:         enrollName,
+				APIKey:       enrollAPIKey,
+				SSHDAddr:     enrollSSHDAddr,
+				LoginTimeout: enrollLoginTimeout,
 			})
 			if err != nil {
 				return err
@@ -97,10 +102,12 @@ Typical setup on a machine you want to reach:
 	enrollCmd.Flags().StringVar(&enrollName, "name", "", "Machine display name (default: hostname)")
 	enrollCmd.Flags().StringVar(&enrollAPIKey, "api-key", "", "API key (phk:...) for headless enrollment")
 	enrollCmd.Flags().StringVar(&enrollSSHDAddr, "sshd-addr", "", "Local sshd address the tunnel exposes (default 127.0.0.1:22)")
+	enrollCmd.Flags().DurationVar(&enrollLoginTimeout, "login-timeout", 0, "How long to wait for the browser login, if one is needed (default: the relay's login session lifetime)")
 
 	// --- tunnel ---
 	var tunnelSSHDAddr string
 	var tunnelDebug bool
+	var tunnelBackoff cli.Backoff
 	tunnelCmd := &cobra.Command{
 		Use:   "tunnel",
 		Short: "Maintain a reverse SSH tunnel to the relay",
@@ -124,11 +131,17 @@ Typical setup on a machine you want to reach:
 				Signer:   signer,
 				Logger:   logger,
 				SSHDAddr: tunnelSSHDAddr,
+				Backoff:  tunnelBackoff,
 			})
 		},
 	}
 	tunnelCmd.Flags().StringVar(&tunnelSSHDAddr, "sshd-addr", "", "Local sshd address the tunnel exposes (default from enrollment, else 127.0.0.1:22)")
 	tunnelCmd.Flags().BoolVar(&tunnelDebug, "debug", false, "Enable debug logging")
+	tunnelCmd.Flags().DurationVar(&tunnelBackoff.Initial, "backoff-initial", cli.DefaultBackoffInitial, "Delay before the first reconnect attempt")
+	tunnelCmd.Flags().DurationVar(&tunnelBackoff.Max, "backoff-max", cli.DefaultBackoffMax, "Upper bound on the reconnect delay")
+	tunnelCmd.Flags().Float64Var(&tunnelBackoff.Multiplier, "backoff-multiplier", cli.DefaultBackoffMultiplier, "Growth factor between reconnect attempts")
+	tunnelCmd.Flags().Float64Var(&tunnelBackoff.Jitter, "backoff-jitter", cli.DefaultBackoffJitter, "Random spread as a fraction of each delay (negative disables)")
+	tunnelCmd.Flags().DurationVar(&tunnelBackoff.ResetAfter, "backoff-reset-after", cli.DefaultBackoffResetAfter, "Uptime after which a dropped tunnel reconnects immediately and the backoff starts over")
 
 	rootCmd.AddCommand(loginCmd, logoutCmd, enrollCmd, tunnelCmd)
 
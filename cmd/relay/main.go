@@ -10,6 +10,8 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -24,16 +26,22 @@ import (
 func main() {
 	godotenv.Load() // load .env if present; no error if missing
 
-	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	logOpts := &slog.HandlerOptions{Level: slog.LevelInfo, ReplaceAttr: relay.RedactSecrets}
+	var logHandler slog.Handler = slog.NewTextHandler(os.Stderr, logOpts)
+	if os.Getenv("LOG_FORMAT") == "json" {
+		logHandler = slog.NewJSONHandler(os.Stderr, logOpts)
+	}
+	logger := slog.New(logHandler)
 
 	addr := os.Getenv("ADDR")
 	if addr == "" {
 		addr = ":8080"
 	}
 
+	tlsCfg := tlsSettingsFromEnv()
 	baseURL := os.Getenv("BASE_URL")
 	if baseURL == "" {
-		baseURL = "http://localhost:8080"
+		baseURL = tlsCfg.defaultBaseURL(addr)
 	}
 
 	devMode := os.Getenv("DEV_MODE") != ""
@@ -63,6 +71,8 @@ func main() {
 			Issuer:        "https://login.microsoftonline.com/common/v2.0",
 			ClientID:      clientID,
 			ClientSecret:  os.Getenv("MICROSOFT_CLIENT_SECRET"),
+			Scopes:        envList("MICROSOFT_SCOPES"),
+			Audience:      os.Getenv("MICROSOFT_AUDIENCE"),
 			DeviceAuthURL: "https://login.microsoftonline.com/common/oauth2/v2.0/devicecode",
 		}); err != nil {
 			logger.Warn("failed to register Microsoft provider", "err", err)
@@ -74,39 +84,61 @@ func main() {
 			Issuer:        "https://accounts.google.com",
 			ClientID:      clientID,
 			ClientSecret:  os.Getenv("GOOGLE_CLIENT_SECRET"),
+			Scopes:        envList("GOOGLE_SCOPES"),
+			Audience:      os.Getenv("GOOGLE_AUDIENCE"),
 			DeviceAuthURL: "https://oauth2.googleapis.com/device/code",
 		}); err != nil {
 			logger.Warn("failed to register Google provider", "err", err)
 		}
 	}
+	if clientID := os.Getenv("GITHUB_CLIENT_ID"); clientID != "" {
+		if err := verifier.AddProvider(ctx, githubConfig(clientID)); err != nil {
+			logger.Warn("failed to register GitHub provider", "err", err)
+		}
+	}
 	if clientID := os.Getenv("APPLE_CLIENT_ID"); clientID != "" {
 		teamID := os.Getenv("APPLE_TEAM_ID")
 		keyID := os.Getenv("APPLE_KEY_ID")
-		pkRaw := os.Getenv("APPLE_PRIVATE_KEY")
+		pkRaw, err := applePrivateKeyPEM()
 
-		if teamID == "" || keyID == "" || pkRaw == "" {
-			logger.Warn("APPLE_CLIENT_ID set but missing APPLE_TEAM_ID, APPLE_KEY_ID, or APPLE_PRIVATE_KEY")
+		if err != nil {
+			logger.Warn("failed to read Apple private key", "err", err)
+		} else if teamID == "" || keyID == "" || len(pkRaw) == 0 {
+			logger.Warn("APPLE_CLIENT_ID set but missing APPLE_TEAM_ID, APPLE_KEY_ID, or APPLE_PRIVATE_KEY/APPLE_P8_PATH")
 		} else {
-			privateKey, err := auth.ParseP8PrivateKey([]byte(pkRaw))
+			privateKey, err := auth.ParseP8PrivateKey(pkRaw)
 			if err != nil {
 				logger.Warn("failed to parse Apple private key", "err", err)
 			} else {
-				if err := verifier.AddProvider(ctx, auth.ProviderConfig{
-					Name:       "apple",
-					Issuer:     "https://appleid.apple.com",
-					ClientID:   clientID,
-					TeamID:     teamID,
-					KeyID:      keyID,
-					PrivateKey: privateKey,
-				}); err != nil {
+				cfg := auth.AppleProvider(clientID, teamID, keyID, privateKey)
+				cfg.Scopes = envList("APPLE_SCOPES")
+				if err := verifier.AddProvider(ctx, cfg); err != nil {
 					logger.Warn("failed to register Apple provider", "err", err)
 				}
 			}
 		}
 	}
+	if path := os.Getenv("PROVIDERS_FILE"); path != "" {
+		configs, err := auth.LoadProvidersFile(path)
+		if err != nil {
+			logger.Warn("ignoring invalid providers file", "path", path, "err", err)
+		}
+		for _, cfg := range configs {
+			if err := verifier.AddProvider(ctx, cfg); err != nil {
+				logger.Warn("failed to register provider from file", "name", cfg.Name, "err", err)
+			}
+		}
+	}
+
+	if domains := envList("ALLOWED_EMAIL_DOMAINS"); len(domains) > 0 {
+		verifier.SetAllowedDomains(domains)
+		logger.Info("restricting sign-in to email domains", "domains", domains)
+	}
 
 	// Pending OIDC auth flows live in-memory (single-instance deployment).
-	authSessions := relay.NewMemoryAuthSessionStore(5 * time.Minute)
+	authSessions := relay.NewMemoryAuthSessionStoreWithSweep(
+		time.Duration(envInt(logger, "AUTH_SESSION_TTL_SECONDS", 300))*time.Second,
+		time.Duration(envInt(logger, "AUTH_SESSION_SWEEP_SECONDS", 30))*time.Second, logger)
 
 	// API key signing secret
 	apiKeySecret := []byte(os.Getenv("API_KEY_SECRET"))
@@ -118,6 +150,33 @@ func main() {
 	}
 
 	srv := relay.NewServer(logger, baseURL, verifier, devMode, authSessions, apiKeySecret, db)
+	srv.SetBridgeLimits(relay.BridgeLimits{
+		MaxTotal:       envInt(logger, "MAX_BRIDGES", 0),
+		MaxPerUser:     envInt(logger, "MAX_BRIDGES_PER_USER", 0),
+		MaxPerMachine:  envInt(logger, "MAX_BRIDGES_PER_MACHINE", 0),
+		MaxDuration:    envDuration(logger, "MAX_SESSION_DURATION", 0),
+		IdleTimeout:    envDuration(logger, "IDLE_TIMEOUT", 0),
+		PingInterval:   envDuration(logger, "PING_INTERVAL", 0),
+		PingTimeout:    envDuration(logger, "PING_TIMEOUT", 0),
+		MaxAuthMessage: int64(envInt(logger, "MAX_AUTH_MESSAGE_BYTES", 0)),
+	})
+	srv.SetAllowedOrigins(envList("ALLOWED_ORIGINS"))
+	if perMin := envInt(logger, "AUTH_RATE_LIMIT", 0); perMin > 0 {
+		srv.SetAuthRateLimit(relay.AuthRateLimit{
+			Rate:       float64(perMin) / 60,
+			Burst:      envInt(logger, "AUTH_RATE_BURST", perMin),
+			TrustProxy: os.Getenv("TRUST_PROXY_HEADERS") != "",
+		})
+	}
+	srv.SetStaticDir(os.Getenv("STATIC_DIR"))
+	srv.SetExpectedProviders(expectedProviders())
+	if os.Getenv("ACCESS_LOG") != "" {
+		srv.EnableAccessLog()
+	}
+	if os.Getenv("METRICS") != "" {
+		srv.EnableMetrics()
+		logger.Info("prometheus metrics enabled", "path", "/metrics")
+	}
 
 	// SSH gateway for CLI reverse tunnels
 	sshAddr := os.Getenv("SSH_ADDR")
@@ -135,6 +194,10 @@ func main() {
 	}
 	registry := sshgate.NewRegistry()
 	gate := sshgate.NewServer(registry, db, hostKey, logger)
+	gate.SetKeepalive(
+		envDuration(logger, "TUNNEL_KEEPALIVE_INTERVAL", 30*time.Second),
+		envDuration(logger, "TUNNEL_KEEPALIVE_TIMEOUT", 15*time.Second),
+	)
 	srv.SetSSHGate(registry, sshPublicAddr(baseURL, sshAddr), hostKey.PublicKey())
 
 	httpServer := &http.Server{
@@ -164,8 +227,8 @@ func main() {
 	}
 
 	go func() {
-		logger.Info("relay server starting", "addr", addr, "base_url", baseURL, "dev_mode", devMode, "ssh_addr", sshAddr)
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Info("relay server starting", "addr", addr, "base_url", baseURL, "dev_mode", devMode, "ssh_addr", sshAddr, "tls", tlsCfg.enabled())
+		if err := tlsCfg.serve(ctx, httpServer, logger); err != nil && err != http.ErrServerClosed {
 			logger.Error("server error", "err", err)
 			cancel()
 		}
@@ -173,14 +236,104 @@ func main() {
 
 	<-ctx.Done()
 	logger.Info("shutting down")
+	// Fail /readyz first so load balancers stop routing here before the
+	// listener closes.
+	srv.BeginShutdown()
+	if drain := envDuration(logger, "SHUTDOWN_DRAIN", 0); drain > 0 {
+		time.Sleep(drain)
+	}
 
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
 	authSessions.Stop()
+	if err := srv.CloseBridges(shutdownCtx); err != nil {
+		logger.Warn("closing browser sessions", "err", err)
+	}
 	httpServer.Shutdown(shutdownCtx)
 }
 
+// expectedProviders lists the built-in providers whose env vars are set, so
+// /readyz can flag any that failed to register. PROVIDERS_FILE entries are
+// logged at startup instead.
+func expectedProviders() []string {
+	var names []string
+	for _, p := range []struct{ name, env string }{
+		{"microsoft", "MICROSOFT_CLIENT_ID"},
+		{"google", "GOOGLE_CLIENT_ID"},
+		{"github", "GITHUB_CLIENT_ID"},
+		{"apple", "APPLE_CLIENT_ID"},
+	} {
+		if os.Getenv(p.env) != "" {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// applePrivateKeyPEM returns the Sign in with Apple .p8 key, taken inline
+// from APPLE_PRIVATE_KEY or read from the file at APPLE_P8_PATH. It returns
+// nil when neither is set.
+func applePrivateKeyPEM() ([]byte, error) {
+	if raw := os.Getenv("APPLE_PRIVATE_KEY"); raw != "" {
+		return []byte(raw), nil
+	}
+	if path := os.Getenv("APPLE_P8_PATH"); path != "" {
+		return os.ReadFile(path)
+	}
+	return nil, nil
+}
+
+// envInt reads a non-negative integer env var, falling back to def when it
+// is unset or invalid.
+func envInt(logger *slog.Logger, name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		logger.Warn("ignoring invalid integer env var", "name", name, "value", raw)
+		return def
+	}
+	return n
+}
+
+// envDuration reads a Go duration env var (e.g. "10m"), falling back to def
+// when it is unset or invalid.
+func envDuration(logger *slog.Logger, name string, def time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		logger.Warn("ignoring invalid duration env var", "name", name, "value", raw)
+		return def
+	}
+	return d
+}
+
+// envList reads a space- or comma-separated env var; nil when unset or
+// blank.
+func envList(name string) []string {
+	fields := strings.Fields(strings.ReplaceAll(os.Getenv(name), ",", " "))
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// githubConfig builds the GitHub provider, with GITHUB_SCOPES replacing its
+// default scopes when set.
+func githubConfig(clientID string) auth.ProviderConfig {
+	cfg := auth.GitHubProvider(clientID, os.Getenv("GITHUB_CLIENT_SECRET"))
+	if scopes := envList("GITHUB_SCOPES"); len(scopes) > 0 {
+		cfg.Scopes = scopes
+	}
+	return cfg
+}
+
 // sshPublicAddr derives the host:port CLIs should dial for the SSH gateway:
 // SSH_PUBLIC_ADDR wins, otherwise the BASE_URL hostname plus the gateway's
 // listen port.
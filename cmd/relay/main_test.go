@@ -0,0 +1,37 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEnvList(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{"", nil},
+		{" , ", nil},
+		{"a", []string{"a"}},
+		{"a, b c,,d", []string{"a", "b", "c", "d"}},
+	}
+	for _, tc := range tests {
+		t.Setenv("PHOSPHOR_TEST_LIST", tc.raw)
+		got := envList("PHOSPHOR_TEST_LIST")
+		if !slices.Equal(got, tc.want) || (tc.want == nil) != (got == nil) {
+			t.Errorf("envList(%q) = %#v, want %#v", tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestGitHubConfig_Scopes(t *testing.T) {
+	t.Setenv("GITHUB_SCOPES", "")
+	if got := githubConfig("id").RequestScopes(); !slices.Equal(got, []string{"read:user", "user:email"}) {
+		t.Errorf("scopes with GITHUB_SCOPES unset = %v, want GitHub's defaults", got)
+	}
+
+	t.Setenv("GITHUB_SCOPES", "read:user,user:email read:org")
+	if got := githubConfig("id").RequestScopes(); !slices.Equal(got, []string{"read:user", "user:email", "read:org"}) {
+		t.Errorf("scopes with GITHUB_SCOPES set = %v", got)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// tlsSettings selects how the relay terminates TLS. A static cert pair wins
+// over ACME; with neither set the relay serves plaintext, which is what dev
+// setups and deployments behind a TLS-terminating proxy want.
+type tlsSettings struct {
+	certFile, keyFile string
+
+	acmeDomains  []string
+	acmeCacheDir string
+	acmeEmail    string
+	acmeHTTPAddr string // HTTP-01 challenges and http->https redirects
+}
+
+func tlsSettingsFromEnv() tlsSettings {
+	t := tlsSettings{
+		certFile:     os.Getenv("TLS_CERT"),
+		keyFile:      os.Getenv("TLS_KEY"),
+		acmeDomains:  envList("ACME_DOMAINS"),
+		acmeCacheDir: os.Getenv("ACME_CACHE_DIR"),
+		acmeEmail:    os.Getenv("ACME_EMAIL"),
+		acmeHTTPAddr: os.Getenv("ACME_HTTP_ADDR"),
+	}
+	if t.acmeCacheDir == "" {
+		t.acmeCacheDir = "/var/lib/phosphor/acme"
+	}
+	if t.acmeHTTPAddr == "" {
+		t.acmeHTTPAddr = ":80"
+	}
+	return t
+}
+
+func (t tlsSettings) staticCert() bool { return t.certFile != "" || t.keyFile != "" }
+func (t tlsSettings) acme() bool       { return !t.staticCert() && len(t.acmeDomains) > 0 }
+func (t tlsSettings) enabled() bool    { return t.staticCert() || t.acme() }
+
+// defaultBaseURL is the BASE_URL to assume when it isn't set explicitly:
+// localhost on addr's port, whatever host addr binds.
+func (t tlsSettings) defaultBaseURL(addr string) string {
+	if t.acme() {
+		return "https://" + t.acmeDomains[0]
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" {
+		port = "8080"
+	}
+	scheme := "http"
+	if t.staticCert() {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort("localhost", port)
+}
+
+// serve runs srv until it is shut down, terminating TLS as configured. Like
+// http.Server.ListenAndServe it returns http.ErrServerClosed after Shutdown.
+func (t tlsSettings) serve(ctx context.Context, srv *http.Server, logger *slog.Logger) error {
+	switch {
+	case t.staticCert():
+		if t.certFile == "" || t.keyFile == "" {
+			return errors.New("TLS_CERT and TLS_KEY must be set together")
+		}
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		return srv.ListenAndServeTLS(t.certFile, t.keyFile)
+
+	case t.acme():
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(t.acmeDomains...),
+			Cache:      autocert.DirCache(t.acmeCacheDir),
+			Email:      t.acmeEmail,
+		}
+		srv.TLSConfig = m.TLSConfig()
+		srv.TLSConfig.MinVersion = tls.VersionTLS12
+
+		challenge := &http.Server{Addr: t.acmeHTTPAddr, Handler: m.HTTPHandler(nil)}
+		go func() {
+			if err := challenge.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("acme http listener error", "addr", t.acmeHTTPAddr, "err", err)
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			challenge.Close()
+		}()
+		logger.Info("acme enabled", "domains", t.acmeDomains, "cache_dir", t.acmeCacheDir)
+		// Certificates come from TLSConfig.GetCertificate.
+		return srv.ListenAndServeTLS("", "")
+
+	default:
+		return srv.ListenAndServe()
+	}
+}
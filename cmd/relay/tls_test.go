@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brporter/phosphor/internal/relay"
+	dbstore "github.com/brporter/phosphor/internal/store"
+)
+
+// writeSelfSignedPair writes a localhost cert/key pair to dir and returns
+// the cert for the client's trust pool.
+func writeSelfSignedPair(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	cert, _ = x509.ParseCertificate(der)
+	return certFile, keyFile, cert
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestTLSSettings_ServesHealthOverHTTPS(t *testing.T) {
+	certFile, keyFile, cert := writeSelfSignedPair(t, t.TempDir())
+	t.Setenv("TLS_CERT", certFile)
+	t.Setenv("TLS_KEY", keyFile)
+	cfg := tlsSettingsFromEnv()
+	if !cfg.enabled() || cfg.defaultBaseURL(":8443") != "https://localhost:8443" {
+		t.Fatalf("settings = %+v", cfg)
+	}
+
+	authSessions := relay.NewMemoryAuthSessionStore(time.Minute)
+	t.Cleanup(authSessions.Stop)
+	srv := relay.NewServer(slog.Default(), "https://localhost", nil, false, authSessions, nil, dbstore.NewFake())
+	addr := freeAddr(t)
+	httpServer := &http.Server{Addr: addr, Handler: srv.Handler()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- cfg.serve(ctx, httpServer, slog.Default()) }()
+	defer httpServer.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	var resp *http.Response
+	var err error
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if resp, err = client.Get("https://" + addr + "/health"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("GET /health over TLS: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" || resp.TLS == nil {
+		t.Fatalf("status=%d body=%q tls=%v", resp.StatusCode, body, resp.TLS != nil)
+	}
+
+	httpServer.Close()
+	if err := <-errc; err != http.ErrServerClosed {
+		t.Errorf("serve returned %v, want ErrServerClosed", err)
+	}
+}
+
+func TestTLSSettings_Modes(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		addr    string
+		wantTLS bool
+		wantURL string
+	}{
+		{"plaintext", nil, ":8080", false, "http://localhost:8080"},
+		{"addr with host", nil, "127.0.0.1:9000", false, "http://localhost:9000"},
+		{"addr with localhost", nil, "localhost:8080", false, "http://localhost:8080"},
+		{"acme", map[string]string{"ACME_DOMAINS": "relay.example.com, alt.example.com"}, ":443", true, "https://relay.example.com"},
+		{"static wins over acme", map[string]string{"TLS_CERT": "c", "TLS_KEY": "k", "ACME_DOMAINS": "relay.example.com"}, ":8080", true, "https://localhost:8080"},
+		{"static with host", map[string]string{"TLS_CERT": "c", "TLS_KEY": "k"}, "0.0.0.0:8443", true, "https://localhost:8443"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, k := range []string{"TLS_CERT", "TLS_KEY", "ACME_DOMAINS"} {
+				t.Setenv(k, tc.env[k])
+			}
+			cfg := tlsSettingsFromEnv()
+			if cfg.enabled() != tc.wantTLS {
+				t.Errorf("enabled = %v, want %v", cfg.enabled(), tc.wantTLS)
+			}
+			if got := cfg.defaultBaseURL(tc.addr); got != tc.wantURL {
+				t.Errorf("defaultBaseURL(%q) = %q, want %q", tc.addr, got, tc.wantURL)
+			}
+		})
+	}
+}
+
+func TestTLSSettings_HalfPairRejected(t *testing.T) {
+	cfg := tlsSettings{certFile: "cert.pem"}
+	if err := cfg.serve(context.Background(), &http.Server{Addr: freeAddr(t)}, slog.Default()); err == nil || err == http.ErrServerClosed {
+		t.Fatalf("serve with only TLS_CERT = %v, want config error", err)
+	}
+}
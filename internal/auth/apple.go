@@ -12,6 +12,23 @@ import (
 	"github.com/go-jose/go-jose/v4/jwt"
 )
 
+// AppleIssuer is Sign in with Apple's OIDC issuer.
+const AppleIssuer = "https://appleid.apple.com"
+
+// AppleProvider returns the config for Sign in with Apple. Apple has no
+// static client secret: the callback mints one per exchange from the team
+// ID, key ID and .p8 key (see GenerateAppleClientSecret).
+func AppleProvider(clientID, teamID, keyID string, privateKey *ecdsa.PrivateKey) ProviderConfig {
+	return ProviderConfig{
+		Name:       "apple",
+		Issuer:     AppleIssuer,
+		ClientID:   clientID,
+		TeamID:     teamID,
+		KeyID:      keyID,
+		PrivateKey: privateKey,
+	}
+}
+
 // GenerateAppleClientSecret creates a signed JWT for use as Apple's client_secret.
 func GenerateAppleClientSecret(teamID, clientID, keyID string, privateKey *ecdsa.PrivateKey) (string, error) {
 	signerKey := jose.SigningKey{Algorithm: jose.ES256, Key: privateKey}
@@ -24,7 +41,7 @@ func GenerateAppleClientSecret(teamID, clientID, keyID string, privateKey *ecdsa
 	claims := jwt.Claims{
 		Issuer:   teamID,
 		Subject:  clientID,
-		Audience: jwt.Audience{"https://appleid.apple.com"},
+		Audience: jwt.Audience{AppleIssuer},
 		IssuedAt: jwt.NewNumericDate(now),
 		Expiry:   jwt.NewNumericDate(now.Add(180 * 24 * time.Hour)),
 	}
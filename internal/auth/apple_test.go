@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -117,3 +118,43 @@ func TestParseP8PrivateKey_RejectsRSA(t *testing.T) {
 		t.Fatal("expected error for RSA key, got nil")
 	}
 }
+
+func TestAppleProvider_CarriesKey(t *testing.T) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseP8PrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := AppleProvider("com.example.web", "TEAMID123", "KEYID456", parsed)
+	if cfg.Name != "apple" || cfg.Issuer != AppleIssuer {
+		t.Fatalf("config = %+v", cfg)
+	}
+	// Discovery can't reach Apple from tests; point it at the mock issuer.
+	cfg.Issuer = newMockOIDCServer(t).URL
+	v := newTestVerifier()
+	if err := v.AddProvider(context.Background(), cfg); err != nil {
+		t.Fatalf("AddProvider: %v", err)
+	}
+
+	got, ok := v.GetProvider("apple")
+	if !ok {
+		t.Fatal("apple provider not registered")
+	}
+	if got.PrivateKey == nil || !got.PrivateKey.Equal(privKey) {
+		t.Fatal("registered config lost the parsed private key")
+	}
+	if got.TeamID != "TEAMID123" || got.KeyID != "KEYID456" || got.ClientID != "com.example.web" {
+		t.Errorf("config = %+v", got)
+	}
+	if _, err := GenerateAppleClientSecret(got.TeamID, got.ClientID, got.KeyID, got.PrivateKey); err != nil {
+		t.Errorf("client secret from registered config: %v", err)
+	}
+}
@@ -16,8 +16,11 @@ type DeviceCodeResponse struct {
 	DeviceCode      string `json:"device_code"`
 	UserCode        string `json:"user_code"`
 	VerificationURI string `json:"verification_uri"`
-	ExpiresIn       int    `json:"expires_in"`
-	Interval        int    `json:"interval"`
+	// VerificationURIComplete embeds the user code (RFC 8628 §3.3.1), so
+	// the user doesn't have to type it. Optional.
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
 }
 
 // DeviceTokenResponse is the response from the token endpoint.
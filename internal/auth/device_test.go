@@ -210,3 +210,19 @@ func TestPollForToken_ContextCanceled(t *testing.T) {
 		t.Errorf("expected ctx.Err()=%v, got %v", ctx.Err(), err)
 	}
 }
+
+func TestRequestDeviceCode_VerificationURIComplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"device_code":"d","user_code":"U","verification_uri":"https://example.com/activate","verification_uri_complete":"https://example.com/activate?user_code=U"}`))
+	}))
+	defer server.Close()
+
+	result, err := RequestDeviceCode(context.Background(), server.URL, "client", []string{"openid"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.VerificationURIComplete != "https://example.com/activate?user_code=U" {
+		t.Errorf("expected VerificationURIComplete to be decoded, got %q", result.VerificationURIComplete)
+	}
+}
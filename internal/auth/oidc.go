@@ -3,13 +3,19 @@ package auth
 import (
 	"context"
 	"crypto/ecdsa"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+
+	"github.com/brporter/phosphor/internal/clock"
 )
 
 // ProviderConfig holds OIDC configuration for a single identity provider.
@@ -19,15 +25,52 @@ type ProviderConfig struct {
 	ClientID      string
 	ClientSecret  string // empty for public clients (CLI)
 	DeviceAuthURL string // for device code flow
+	// Scopes requested in the browser authorize redirect; defaultScopes
+	// when empty.
+	Scopes []string
+	// Audience, when set, is sent as the authorize "audience" parameter
+	// for providers that issue tokens for a specific API.
+	Audience string
+	// Explicit endpoints for OAuth2 providers without OIDC discovery
+	// (e.g. GitHub); Issuer is left empty for these. Their bearer tokens
+	// are opaque access tokens, resolved to an Identity by calling
+	// UserInfoURL and passing the body to MapUserInfo. When EmailsURL is
+	// set it is called with the same token and MapEmails picks the
+	// account's verified address, replacing the one from UserInfoURL.
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	MapUserInfo func(body []byte) (sub, email string, err error)
+	EmailsURL   string
+	MapEmails   func(body []byte) (email string, err error)
+	// AcceptsToken, when set, picks which opaque tokens are sent to
+	// UserInfoURL, so arbitrary bearer strings don't become outbound
+	// calls to the provider. Nil sends every opaque token.
+	AcceptsToken func(token string) bool
 	// Apple-specific fields
 	TeamID     string            // Apple Developer Team ID
 	KeyID      string            // Apple key ID
 	PrivateKey *ecdsa.PrivateKey // Apple P8 signing key
 }
 
+// defaultScopes are requested when a provider configures none.
+var defaultScopes = []string{"openid", "email", "profile"}
+
+// RequestScopes returns a copy of the scopes to request for this provider.
+func (c ProviderConfig) RequestScopes() []string {
+	if len(c.Scopes) == 0 {
+		return slices.Clone(defaultScopes)
+	}
+	return slices.Clone(c.Scopes)
+}
+
 // ErrNoToken is returned when no auth token is provided.
 var ErrNoToken = errors.New("no authentication token provided")
 
+// ErrDomainNotAllowed is returned for a valid token whose email is not in
+// one of the verifier's allowed domains.
+var ErrDomainNotAllowed = errors.New("email domain not allowed")
+
 // Identity represents a verified user.
 type Identity struct {
 	Provider string // provider name
@@ -37,11 +80,18 @@ type Identity struct {
 
 // Verifier validates tokens from multiple OIDC providers.
 type Verifier struct {
-	mu        sync.RWMutex
-	providers map[string]*providerEntry
-	logger    *slog.Logger
+	mu             sync.RWMutex
+	providers      map[string]*providerEntry
+	allowedDomains map[string]bool // empty allows every identity
+	logger         *slog.Logger
+	clock          clock.Clock
+
+	userInfoMu sync.Mutex
+	userInfo   map[userInfoKey]userInfoEntry
 }
 
+// providerEntry is one registered provider. provider and verifier are nil
+// for explicit-endpoint (userinfo) providers.
 type providerEntry struct {
 	config   ProviderConfig
 	provider *oidc.Provider
@@ -53,11 +103,90 @@ func NewVerifier(logger *slog.Logger) *Verifier {
 	return &Verifier{
 		providers: make(map[string]*providerEntry),
 		logger:    logger,
+		clock:     clock.Real,
+		userInfo:  make(map[userInfoKey]userInfoEntry),
+	}
+}
+
+// SetAllowedDomains restricts verified identities to emails in the given
+// domains (case-insensitive, exact match). An empty list allows everyone.
+// Call during startup.
+func (v *Verifier) SetAllowedDomains(domains []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.allowedDomains = make(map[string]bool, len(domains))
+	for _, d := range domains {
+		v.allowedDomains[strings.ToLower(strings.TrimPrefix(d, "@"))] = true
+	}
+}
+
+// RestrictsDomains reports whether an email domain allow-list is set.
+func (v *Verifier) RestrictsDomains() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.allowedDomains) > 0
+}
+
+// CheckRecordedEmail applies the allow-list to an email the relay recorded
+// from an earlier verified sign-in, such as an API key owner's.
+func (v *Verifier) CheckRecordedEmail(email string) error {
+	verified := claimBool(true)
+	return v.checkDomain(email, &verified)
+}
+
+// checkDomain enforces the allow-list. Callers must not hold v.mu. An
+// email the provider doesn't mark verified never matches, since anyone can
+// claim an address they don't control.
+func (v *Verifier) checkDomain(email string, emailVerified *claimBool) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if len(v.allowedDomains) == 0 {
+		return nil
+	}
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 || emailVerified == nil || !bool(*emailVerified) ||
+		!v.allowedDomains[strings.ToLower(email[at+1:])] {
+		return ErrDomainNotAllowed
 	}
+	return nil
+}
+
+// emailClaims are the ID token claims checkDomain looks at.
+type emailClaims struct {
+	Email         string     `json:"email"`
+	EmailVerified *claimBool `json:"email_verified"`
+	// Entra ID never sends email_verified, and a /common token can carry
+	// any email its tenant's admin typed in. xms_edov is the optional
+	// claim saying the tenant owns the email's domain.
+	XmsEdov *claimBool `json:"xms_edov"`
+}
+
+func (c emailClaims) verified() *claimBool {
+	if c.EmailVerified != nil {
+		return c.EmailVerified
+	}
+	return c.XmsEdov
+}
+
+// claimBool decodes a boolean claim that some providers (Apple) send as a
+// JSON string.
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseBool(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*b = claimBool(v)
+	return nil
 }
 
 // AddProvider registers an OIDC provider. Call during startup.
 func (v *Verifier) AddProvider(ctx context.Context, cfg ProviderConfig) error {
+	if cfg.UserInfoURL != "" {
+		return v.addUserInfoProvider(cfg)
+	}
+
 	// Microsoft's /common/v2.0 discovery doc returns "{tenantid}" as a
 	// placeholder in the issuer field, which doesn't match the discovery URL.
 	// Skip the issuer check for multi-tenant Microsoft endpoints.
@@ -95,21 +224,53 @@ func (v *Verifier) AddProvider(ctx context.Context, cfg ProviderConfig) error {
 // VerifyToken verifies an ID token and returns the identity.
 // It tries all registered providers until one succeeds.
 func (v *Verifier) VerifyToken(ctx context.Context, rawToken string) (*Identity, error) {
+	if rawToken == "" {
+		return nil, ErrNoToken
+	}
+	// Verification may call out to providers, so it runs on a snapshot
+	// rather than under v.mu.
 	v.mu.RLock()
-	defer v.mu.RUnlock()
+	names := make([]string, 0, len(v.providers))
+	entries := make([]*providerEntry, 0, len(v.providers))
+	for name, entry := range v.providers {
+		names = append(names, name)
+		entries = append(entries, entry)
+	}
+	v.mu.RUnlock()
+
+	// Only opaque tokens go to userinfo providers, so an ID token is never
+	// handed to a third party's API.
+	opaque := !looksLikeJWT(rawToken)
 
 	var lastErr error
-	for name, entry := range v.providers {
+	for i, entry := range entries {
+		name := names[i]
+		if entry.verifier == nil {
+			if !opaque || (entry.config.AcceptsToken != nil && !entry.config.AcceptsToken(rawToken)) {
+				continue
+			}
+			id, verified, err := v.cachedUserInfo(ctx, name, entry.config, rawToken)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if err := v.checkDomain(id.Email, verified); err != nil {
+				return nil, err
+			}
+			return id, nil
+		}
+
 		idToken, err := entry.verifier.Verify(ctx, rawToken)
 		if err != nil {
 			lastErr = err
 			continue
 		}
 
-		var claims struct {
-			Email string `json:"email"`
-		}
+		var claims emailClaims
 		idToken.Claims(&claims)
+		if err := v.checkDomain(claims.Email, claims.verified()); err != nil {
+			return nil, err
+		}
 
 		return &Identity{
 			Provider: name,
@@ -121,9 +282,43 @@ func (v *Verifier) VerifyToken(ctx context.Context, rawToken string) (*Identity,
 	if lastErr != nil {
 		return nil, fmt.Errorf("token verification failed: %w", lastErr)
 	}
+	if len(entries) > 0 {
+		return nil, errors.New("token verification failed: no provider accepts this kind of token")
+	}
 	return nil, errors.New("no OIDC providers configured")
 }
 
+// VerifyProviderToken verifies an ID token against one named provider and
+// requires its nonce claim to equal nonce. Use it where the token arrived
+// through the browser (e.g. Apple's form_post) and could have been forged
+// or replayed from another login.
+func (v *Verifier) VerifyProviderToken(ctx context.Context, name, rawToken, nonce string) (*Identity, error) {
+	v.mu.RLock()
+	entry, ok := v.providers[name]
+	v.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+	if entry.verifier == nil {
+		return nil, fmt.Errorf("provider %q does not issue ID tokens", name)
+	}
+
+	idToken, err := entry.verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return nil, fmt.Errorf("token verification failed: %w", err)
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("token nonce mismatch")
+	}
+
+	var claims emailClaims
+	idToken.Claims(&claims)
+	if err := v.checkDomain(claims.Email, claims.verified()); err != nil {
+		return nil, err
+	}
+	return &Identity{Provider: name, Sub: idToken.Subject, Email: claims.Email}, nil
+}
+
 // ProviderNames returns the names of all registered providers in sorted order.
 func (v *Verifier) ProviderNames() []string {
 	v.mu.RLock()
@@ -152,7 +347,7 @@ func (v *Verifier) GetOIDCProvider(name string) (*oidc.Provider, bool) {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 	entry, ok := v.providers[name]
-	if !ok {
+	if !ok || entry.provider == nil {
 		return nil, false
 	}
 	return entry.provider, true
@@ -166,6 +361,9 @@ func (v *Verifier) GetAuthEndpoint(name string) (string, bool) {
 	if !ok {
 		return "", false
 	}
+	if entry.config.AuthURL != "" {
+		return entry.config.AuthURL, true
+	}
 	var claims struct {
 		AuthURL string `json:"authorization_endpoint"`
 	}
@@ -183,6 +381,9 @@ func (v *Verifier) GetTokenEndpoint(name string) (string, bool) {
 	if !ok {
 		return "", false
 	}
+	if entry.config.TokenURL != "" {
+		return entry.config.TokenURL, true
+	}
 	var claims struct {
 		TokenURL string `json:"token_endpoint"`
 	}
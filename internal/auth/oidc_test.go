@@ -2,12 +2,20 @@ package auth
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
 )
 
 // newMockOIDCServer creates a test HTTP server that mimics an OIDC discovery endpoint.
@@ -262,3 +270,153 @@ func TestVerifyToken_InvalidToken(t *testing.T) {
 		t.Errorf("error %q does not contain 'token verification failed'", err.Error())
 	}
 }
+
+func TestCheckDomain(t *testing.T) {
+	yes, no := claimBool(true), claimBool(false)
+	tests := []struct {
+		name     string
+		allowed  []string
+		email    string
+		verified *claimBool
+		wantErr  bool
+	}{
+		{"empty list allows all", nil, "anyone@anywhere.org", nil, false},
+		{"empty list allows no email", nil, "", nil, false},
+		{"allowed", []string{"company.com"}, "alice@company.com", &yes, false},
+		{"case-insensitive", []string{"Company.com"}, "alice@COMPANY.com", &yes, false},
+		{"disallowed", []string{"company.com"}, "mallory@evil.com", &yes, true},
+		{"subdomain is not the domain", []string{"company.com"}, "bob@eng.company.com", &yes, true},
+		{"missing email", []string{"company.com"}, "", &yes, true},
+		{"unverified email", []string{"company.com"}, "alice@company.com", &no, true},
+		{"verification unknown", []string{"company.com"}, "alice@company.com", nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newTestVerifier()
+			v.SetAllowedDomains(tc.allowed)
+			err := v.checkDomain(tc.email, tc.verified)
+			if tc.wantErr && !errors.Is(err, ErrDomainNotAllowed) {
+				t.Errorf("err = %v, want ErrDomainNotAllowed", err)
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected err: %v", err)
+			}
+		})
+	}
+}
+
+// newSigningOIDCServer serves discovery and a JWKS with a real key, and
+// returns the issuer URL and a function that signs ID tokens for client
+// "phosphor" with the extra claims given.
+func newSigningOIDCServer(t *testing.T) (string, func(extra map[string]any) string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := jose.JSONWebKey{Key: &key.PublicKey, KeyID: "k1", Algorithm: string(jose.ES256), Use: "sig"}
+
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                srv.URL,
+			"authorization_endpoint":                srv.URL + "/authorize",
+			"token_endpoint":                        srv.URL + "/token",
+			"jwks_uri":                              srv.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"ES256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk}})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	sign := func(extra map[string]any) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key},
+			(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "k1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		claims := map[string]any{
+			"iss": srv.URL,
+			"aud": "phosphor",
+			"sub": "user-1",
+			"iat": now.Unix(),
+			"exp": now.Add(5 * time.Minute).Unix(),
+		}
+		for k, v := range extra {
+			claims[k] = v
+		}
+		raw, err := jwt.Signed(signer).Claims(claims).Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	return srv.URL, sign
+}
+
+// TestVerifyToken_MicrosoftEmailVerification covers the nOAuth case: Entra
+// ID tokens carry no email_verified, so a /common token's email only counts
+// for the allow-list when xms_edov vouches for it.
+func TestVerifyToken_MicrosoftEmailVerification(t *testing.T) {
+	issuer, sign := newSigningOIDCServer(t)
+	v := newTestVerifier()
+	if err := v.AddProvider(context.Background(), ProviderConfig{Name: "microsoft", Issuer: issuer, ClientID: "phosphor"}); err != nil {
+		t.Fatal(err)
+	}
+	v.SetAllowedDomains([]string{"company.com"})
+
+	tests := []struct {
+		name    string
+		claims  map[string]any
+		wantErr bool
+	}{
+		{"no verification claim", map[string]any{"email": "ceo@company.com", "tid": "attacker-tenant"}, true},
+		{"domain not owner-verified", map[string]any{"email": "ceo@company.com", "xms_edov": false}, true},
+		{"domain owner-verified", map[string]any{"email": "ceo@company.com", "xms_edov": true}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			id, err := v.VerifyToken(context.Background(), sign(tc.claims))
+			if tc.wantErr && !errors.Is(err, ErrDomainNotAllowed) {
+				t.Fatalf("VerifyToken = %+v, %v; want ErrDomainNotAllowed", id, err)
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("VerifyToken: %v", err)
+			}
+		})
+	}
+}
+
+func TestRequestScopes_ReturnsCopies(t *testing.T) {
+	var cfg ProviderConfig
+	cfg.RequestScopes()[0] = "mutated"
+	if got := cfg.RequestScopes(); got[0] != "openid" {
+		t.Errorf("default scopes changed through a returned slice: %v", got)
+	}
+
+	cfg.Scopes = []string{"read:user"}
+	cfg.RequestScopes()[0] = "mutated"
+	if cfg.Scopes[0] != "read:user" {
+		t.Errorf("configured scopes changed through a returned slice: %v", cfg.Scopes)
+	}
+}
+
+func TestClaimBool_AcceptsStrings(t *testing.T) {
+	var claims struct {
+		A *claimBool `json:"a"`
+		B *claimBool `json:"b"`
+	}
+	if err := json.Unmarshal([]byte(`{"a":"false","b":true}`), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.A == nil || bool(*claims.A) || claims.B == nil || !bool(*claims.B) {
+		t.Errorf("decoded a=%v b=%v", claims.A, claims.B)
+	}
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// providerFileEntry is one element of a providers file. Only OIDC providers
+// (discovered from Issuer) can be declared this way; OAuth-only providers
+// like GitHub need a MapUserInfo func and are wired in code.
+type providerFileEntry struct {
+	Name          string   `json:"name"`
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"client_id"`
+	ClientSecret  string   `json:"client_secret"`
+	DeviceAuthURL string   `json:"device_auth_url"`
+	Scopes        []string `json:"scopes"`
+	Audience      string   `json:"audience"`
+	// Optional overrides for the discovered endpoints.
+	AuthURL  string `json:"auth_url"`
+	TokenURL string `json:"token_url"`
+}
+
+// LoadProvidersFile reads a JSON array of OIDC provider definitions, e.g.
+//
+//	[{"name": "okta", "issuer": "https://example.okta.com", "client_id": "..."}]
+//
+// Every entry needs a unique name, an issuer and a client_id. The configs
+// are returned unregistered; pass each to AddProvider.
+func LoadProvidersFile(path string) ([]ProviderConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read providers file: %w", err)
+	}
+	var entries []providerFileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("parse providers file %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool, len(entries))
+	configs := make([]ProviderConfig, 0, len(entries))
+	var errs []error
+	for i, e := range entries {
+		switch {
+		case e.Name == "":
+			errs = append(errs, fmt.Errorf("providers file entry %d: name is required", i))
+			continue
+		case e.Issuer == "" || e.ClientID == "":
+			errs = append(errs, fmt.Errorf("provider %s: issuer and client_id are required", e.Name))
+			continue
+		case seen[e.Name]:
+			errs = append(errs, fmt.Errorf("provider %s: declared more than once", e.Name))
+			continue
+		}
+		seen[e.Name] = true
+		configs = append(configs, ProviderConfig{
+			Name:          e.Name,
+			Issuer:        e.Issuer,
+			ClientID:      e.ClientID,
+			ClientSecret:  e.ClientSecret,
+			DeviceAuthURL: e.DeviceAuthURL,
+			Scopes:        e.Scopes,
+			Audience:      e.Audience,
+			AuthURL:       e.AuthURL,
+			TokenURL:      e.TokenURL,
+		})
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return configs, nil
+}
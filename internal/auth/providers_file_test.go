@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeProvidersFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "providers.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadProvidersFile_RegistersProvider(t *testing.T) {
+	srv := newMockOIDCServer(t)
+	path := writeProvidersFile(t, `[{
+		"name": "okta",
+		"issuer": "`+srv.URL+`",
+		"client_id": "okta-client",
+		"client_secret": "s3cret",
+		"scopes": ["openid", "email"],
+		"auth_url": "https://login.example.com/authorize"
+	}]`)
+
+	configs, err := LoadProvidersFile(path)
+	if err != nil {
+		t.Fatalf("LoadProvidersFile: %v", err)
+	}
+	v := newTestVerifier()
+	for _, cfg := range configs {
+		if err := v.AddProvider(context.Background(), cfg); err != nil {
+			t.Fatalf("AddProvider: %v", err)
+		}
+	}
+
+	got, ok := v.GetProvider("okta")
+	if !ok {
+		t.Fatal("GetProvider(okta) = false")
+	}
+	if got.ClientID != "okta-client" || got.ClientSecret != "s3cret" || len(got.Scopes) != 2 {
+		t.Errorf("config = %+v", got)
+	}
+	if ep, _ := v.GetAuthEndpoint("okta"); ep != "https://login.example.com/authorize" {
+		t.Errorf("auth endpoint = %q, want the override", ep)
+	}
+	if ep, _ := v.GetTokenEndpoint("okta"); ep != srv.URL+"/token" {
+		t.Errorf("token endpoint = %q, want the discovered one", ep)
+	}
+}
+
+func TestLoadProvidersFile_Validation(t *testing.T) {
+	tests := []struct {
+		name, body, wantErr string
+	}{
+		{"missing name", `[{"issuer":"https://i","client_id":"c"}]`, "name is required"},
+		{"missing issuer", `[{"name":"x","client_id":"c"}]`, "issuer and client_id are required"},
+		{"missing client id", `[{"name":"x","issuer":"https://i"}]`, "issuer and client_id are required"},
+		{"duplicate", `[{"name":"x","issuer":"https://i","client_id":"c"},{"name":"x","issuer":"https://j","client_id":"d"}]`, "declared more than once"},
+		{"not an array", `{"name":"x"}`, "parse providers file"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadProvidersFile(writeProvidersFile(t, tc.body))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("err = %v, want containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadProvidersFile_Missing(t *testing.T) {
+	if _, err := LoadProvidersFile(filepath.Join(t.TempDir(), "nope.json")); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
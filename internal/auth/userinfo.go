@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userInfoClient bounds calls to userinfo endpoints, which sit on the
+// request path of every authenticated API call for these providers.
+var userInfoClient = &http.Client{Timeout: 10 * time.Second}
+
+// Resolved identities are cached by token hash so a busy client doesn't
+// cost one provider API call per request; rejections are cached for less
+// time, long enough to absorb a retry loop with a revoked token.
+const (
+	userInfoCacheTTL    = time.Minute
+	userInfoNegativeTTL = 10 * time.Second
+	userInfoCacheMax    = 1024
+)
+
+type userInfoKey struct {
+	provider string
+	token    [sha256.Size]byte
+}
+
+type userInfoEntry struct {
+	id       Identity
+	verified *claimBool
+	err      error
+	expires  time.Time
+}
+
+// statusError is a non-200 response from a provider API: a definite answer
+// about the token, unlike a network failure.
+type statusError int
+
+func (e statusError) Error() string { return fmt.Sprintf("status %d", int(e)) }
+
+// GitHubProvider returns the config for signing in with a GitHub OAuth app.
+// GitHub has no OIDC discovery or ID tokens, so identities come from the
+// /user API, and the email from /user/emails (which the user:email scope
+// unlocks) since /user only shows an address the user made public.
+func GitHubProvider(clientID, clientSecret string) ProviderConfig {
+	return ProviderConfig{
+		Name:         "github",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"read:user", "user:email"},
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		UserInfoURL:  "https://api.github.com/user",
+		MapUserInfo:  MapGitHubUser,
+		EmailsURL:    "https://api.github.com/user/emails",
+		MapEmails:    MapGitHubEmails,
+		AcceptsToken: IsGitHubUserToken,
+	}
+}
+
+// IsGitHubUserToken reports whether token has the prefix GitHub gives
+// user access tokens: gho_ from OAuth apps, ghu_ from GitHub Apps.
+func IsGitHubUserToken(token string) bool {
+	return strings.HasPrefix(token, "gho_") || strings.HasPrefix(token, "ghu_")
+}
+
+// MapGitHubUser extracts the subject (the numeric account ID, which unlike
+// the login never changes) and public email from a GitHub /user response.
+func MapGitHubUser(body []byte) (string, string, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &user); err != nil {
+		return "", "", fmt.Errorf("decode GitHub user: %w", err)
+	}
+	if user.ID == 0 {
+		return "", "", errors.New("GitHub user response has no id")
+	}
+	return strconv.FormatInt(user.ID, 10), user.Email, nil
+}
+
+// MapGitHubEmails returns the primary address from a GitHub /user/emails
+// response, or "" when that address is not verified.
+func MapGitHubEmails(body []byte) (string, error) {
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := json.Unmarshal(body, &emails); err != nil {
+		return "", fmt.Errorf("decode GitHub emails: %w", err)
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			return e.Email, nil
+		}
+	}
+	return "", nil
+}
+
+func (v *Verifier) addUserInfoProvider(cfg ProviderConfig) error {
+	if cfg.AuthURL == "" || cfg.TokenURL == "" || cfg.MapUserInfo == nil {
+		return fmt.Errorf("provider %s: AuthURL, TokenURL and MapUserInfo are required with UserInfoURL", cfg.Name)
+	}
+	if (cfg.EmailsURL == "") != (cfg.MapEmails == nil) {
+		return fmt.Errorf("provider %s: EmailsURL and MapEmails must be set together", cfg.Name)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.providers[cfg.Name] = &providerEntry{config: cfg}
+	v.logger.Info("OAuth provider registered", "name", cfg.Name, "userinfo", cfg.UserInfoURL)
+	return nil
+}
+
+// cachedUserInfo is fetchUserInfo behind the verifier's userinfo cache.
+func (v *Verifier) cachedUserInfo(ctx context.Context, name string, cfg ProviderConfig, token string) (*Identity, *claimBool, error) {
+	key := userInfoKey{provider: name, token: sha256.Sum256([]byte(token))}
+	now := v.clock.Now()
+
+	v.userInfoMu.Lock()
+	e, ok := v.userInfo[key]
+	v.userInfoMu.Unlock()
+	if ok && now.Before(e.expires) {
+		if e.err != nil {
+			return nil, nil, e.err
+		}
+		id := e.id
+		return &id, e.verified, nil
+	}
+
+	id, verified, err := v.fetchUserInfo(ctx, name, cfg, token)
+	var status statusError
+	switch {
+	case err == nil:
+		v.storeUserInfo(key, userInfoEntry{id: *id, verified: verified, expires: now.Add(userInfoCacheTTL)})
+	case errors.As(err, &status):
+		v.storeUserInfo(key, userInfoEntry{err: err, expires: now.Add(userInfoNegativeTTL)})
+	}
+	return id, verified, err
+}
+
+func (v *Verifier) storeUserInfo(key userInfoKey, e userInfoEntry) {
+	v.userInfoMu.Lock()
+	defer v.userInfoMu.Unlock()
+	if len(v.userInfo) >= userInfoCacheMax {
+		now := v.clock.Now()
+		for k, old := range v.userInfo {
+			if !now.Before(old.expires) {
+				delete(v.userInfo, k)
+			}
+		}
+		if len(v.userInfo) >= userInfoCacheMax {
+			clear(v.userInfo)
+		}
+	}
+	v.userInfo[key] = e
+}
+
+// fetchUserInfo resolves an opaque access token to an Identity through the
+// provider's userinfo endpoint. verified is non-nil only when the email came
+// from the provider's EmailsURL; a userinfo email alone is unverified.
+func (v *Verifier) fetchUserInfo(ctx context.Context, name string, cfg ProviderConfig, token string) (*Identity, *claimBool, error) {
+	body, err := getWithToken(ctx, cfg.UserInfoURL, token)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s userinfo: %w", name, err)
+	}
+	sub, email, err := cfg.MapUserInfo(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	id := &Identity{Provider: name, Sub: sub, Email: email}
+	if cfg.EmailsURL == "" {
+		return id, nil, nil
+	}
+
+	// A token granted without the emails scope still identifies the user;
+	// it just can't vouch for an address.
+	body, err = getWithToken(ctx, cfg.EmailsURL, token)
+	if err != nil {
+		v.logger.Debug("userinfo emails unavailable", "provider", name, "err", err)
+		return id, nil, nil
+	}
+	if id.Email, err = cfg.MapEmails(body); err != nil {
+		return nil, nil, err
+	}
+	verified := claimBool(id.Email != "")
+	return id, &verified, nil
+}
+
+// getWithToken GETs url with a bearer token and returns the body of a 200
+// response.
+func getWithToken(ctx context.Context, url, token string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "phosphor-relay")
+
+	resp, err := userInfoClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp.StatusCode)
+	}
+	return body, nil
+}
+
+// looksLikeJWT reports whether token has the three dot-separated segments
+// of a compact JWS.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
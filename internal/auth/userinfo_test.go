@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brporter/phosphor/internal/clock"
+)
+
+// newMockGitHub serves GitHub-shaped /user and /user/emails endpoints that
+// accept "gho_valid" and counts every call it receives. The user keeps their
+// email private, so only /user/emails reveals it.
+func newMockGitHub(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get("Authorization") != "Bearer gho_valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"Bad credentials"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/user":
+			w.Write([]byte(`{"login":"octocat","id":583231,"email":null,"name":"The Octocat"}`))
+		case "/user/emails":
+			w.Write([]byte(`[{"email":"octocat@users.noreply.github.com","primary":false,"verified":true},` +
+				`{"email":"octocat@github.com","primary":true,"verified":true}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func newGitHubVerifier(t *testing.T, srv *httptest.Server) *Verifier {
+	t.Helper()
+	cfg := GitHubProvider("client", "secret")
+	cfg.UserInfoURL = srv.URL + "/user"
+	cfg.EmailsURL = srv.URL + "/user/emails"
+	v := newTestVerifier()
+	if err := v.AddProvider(context.Background(), cfg); err != nil {
+		t.Fatalf("AddProvider: %v", err)
+	}
+	return v
+}
+
+func TestUserInfoProvider_VerifyToken(t *testing.T) {
+	srv, _ := newMockGitHub(t)
+	v := newGitHubVerifier(t, srv)
+
+	id, err := v.VerifyToken(context.Background(), "gho_valid")
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	if id.Provider != "github" || id.Sub != "583231" || id.Email != "octocat@github.com" {
+		t.Errorf("identity = %+v", id)
+	}
+}
+
+func TestUserInfoProvider_RejectsBadToken(t *testing.T) {
+	srv, _ := newMockGitHub(t)
+	v := newGitHubVerifier(t, srv)
+
+	_, err := v.VerifyToken(context.Background(), "gho_revoked")
+	if err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Fatalf("VerifyToken err = %v, want a 401 failure", err)
+	}
+}
+
+func TestUserInfoProvider_NeverSeesJWTs(t *testing.T) {
+	srv, calls := newMockGitHub(t)
+	v := newGitHubVerifier(t, srv)
+
+	if _, err := v.VerifyToken(context.Background(), "eyJhbGciOiJFUzI1NiJ9.eyJzdWIiOiJ4In0.c2ln"); err == nil {
+		t.Fatal("expected JWT to be rejected")
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("userinfo endpoint called %d times for an ID token", n)
+	}
+}
+
+func TestUserInfoProvider_Endpoints(t *testing.T) {
+	srv, _ := newMockGitHub(t)
+	v := newGitHubVerifier(t, srv)
+
+	if u, ok := v.GetAuthEndpoint("github"); !ok || u != "https://github.com/login/oauth/authorize" {
+		t.Errorf("GetAuthEndpoint = %q, %v", u, ok)
+	}
+	if u, ok := v.GetTokenEndpoint("github"); !ok || u != "https://github.com/login/oauth/access_token" {
+		t.Errorf("GetTokenEndpoint = %q, %v", u, ok)
+	}
+	if _, ok := v.GetOIDCProvider("github"); ok {
+		t.Error("GetOIDCProvider should report no OIDC provider for github")
+	}
+}
+
+func TestAddProvider_UserInfoRequiresEndpoints(t *testing.T) {
+	v := newTestVerifier()
+	err := v.AddProvider(context.Background(), ProviderConfig{Name: "x", UserInfoURL: "http://example/user"})
+	if err == nil {
+		t.Fatal("expected error for userinfo provider without endpoints")
+	}
+}
+
+func TestMapGitHubUser(t *testing.T) {
+	sub, email, err := MapGitHubUser([]byte(`{"id":42,"email":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub != "42" || email != "" {
+		t.Errorf("got sub=%q email=%q, want 42 and empty", sub, email)
+	}
+	if _, _, err := MapGitHubUser([]byte(`{"login":"ghost"}`)); err == nil {
+		t.Error("expected error when id is missing")
+	}
+}
+
+func TestMapGitHubEmails(t *testing.T) {
+	email, err := MapGitHubEmails([]byte(`[{"email":"a@x.com","primary":false,"verified":true},{"email":"b@x.com","primary":true,"verified":true}]`))
+	if err != nil || email != "b@x.com" {
+		t.Errorf("got %q, %v; want the primary address", email, err)
+	}
+	email, err = MapGitHubEmails([]byte(`[{"email":"b@x.com","primary":true,"verified":false}]`))
+	if err != nil || email != "" {
+		t.Errorf("got %q, %v; want empty for an unverified primary", email, err)
+	}
+}
+
+func TestUserInfoProvider_EmailsUnavailable(t *testing.T) {
+	srv, _ := newMockGitHub(t)
+	cfg := GitHubProvider("client", "secret")
+	cfg.UserInfoURL = srv.URL + "/user"
+	cfg.EmailsURL = srv.URL + "/missing"
+	v := newTestVerifier()
+	if err := v.AddProvider(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := v.VerifyToken(context.Background(), "gho_valid")
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	if id.Sub != "583231" || id.Email != "" {
+		t.Errorf("identity = %+v, want the user without an email", id)
+	}
+}
+
+func TestUserInfoProvider_DomainAllowList(t *testing.T) {
+	srv, _ := newMockGitHub(t)
+	v := newGitHubVerifier(t, srv)
+
+	v.SetAllowedDomains([]string{"example.com"})
+	if _, err := v.VerifyToken(context.Background(), "gho_valid"); !errors.Is(err, ErrDomainNotAllowed) {
+		t.Fatalf("VerifyToken err = %v, want ErrDomainNotAllowed", err)
+	}
+
+	v.SetAllowedDomains([]string{"example.com", "@github.com"})
+	if _, err := v.VerifyToken(context.Background(), "gho_valid"); err != nil {
+		t.Fatalf("VerifyToken with allowed domain: %v", err)
+	}
+}
+
+func TestUserInfoProvider_EmptyTokenSkipsAPI(t *testing.T) {
+	srv, calls := newMockGitHub(t)
+	v := newGitHubVerifier(t, srv)
+
+	if _, err := v.VerifyToken(context.Background(), ""); !errors.Is(err, ErrNoToken) {
+		t.Fatalf("VerifyToken err = %v, want ErrNoToken", err)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("userinfo endpoint called %d times for an empty token", n)
+	}
+}
+
+func TestUserInfoProvider_CachesIdentity(t *testing.T) {
+	srv, calls := newMockGitHub(t)
+	v := newGitHubVerifier(t, srv)
+	clk := clock.NewFake(time.Now())
+	v.clock = clk
+
+	for range 3 {
+		if _, err := v.VerifyToken(context.Background(), "gho_valid"); err != nil {
+			t.Fatalf("VerifyToken: %v", err)
+		}
+	}
+	// One /user and one /user/emails call for the first verification.
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("API called %d times, want 2", n)
+	}
+
+	clk.Advance(userInfoCacheTTL)
+	if _, err := v.VerifyToken(context.Background(), "gho_valid"); err != nil {
+		t.Fatalf("VerifyToken after expiry: %v", err)
+	}
+	if n := calls.Load(); n != 4 {
+		t.Errorf("API called %d times after expiry, want 4", n)
+	}
+}
+
+func TestUserInfoProvider_CachesRejection(t *testing.T) {
+	srv, calls := newMockGitHub(t)
+	v := newGitHubVerifier(t, srv)
+	clk := clock.NewFake(time.Now())
+	v.clock = clk
+
+	for range 3 {
+		if _, err := v.VerifyToken(context.Background(), "gho_revoked"); err == nil {
+			t.Fatal("expected revoked token to be rejected")
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("API called %d times, want 1", n)
+	}
+
+	clk.Advance(userInfoNegativeTTL)
+	v.VerifyToken(context.Background(), "gho_revoked")
+	if n := calls.Load(); n != 2 {
+		t.Errorf("API called %d times after expiry, want 2", n)
+	}
+}
+
+func TestUserInfoProvider_OnlyGitHubTokens(t *testing.T) {
+	srv, calls := newMockGitHub(t)
+	v := newGitHubVerifier(t, srv)
+
+	for _, tok := range []string{"random-opaque-token", "ghp_classic_pat", "github_pat_x"} {
+		if _, err := v.VerifyToken(context.Background(), tok); err == nil {
+			t.Errorf("VerifyToken(%q) succeeded", tok)
+		}
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("GitHub API called %d times for tokens it never issues to OAuth apps", n)
+	}
+}
+
+func TestIsGitHubUserToken(t *testing.T) {
+	for tok, want := range map[string]bool{
+		"gho_abc": true, "ghu_abc": true, "ghp_abc": false, "abc": false, "": false,
+	} {
+		if got := IsGitHubUserToken(tok); got != want {
+			t.Errorf("IsGitHubUserToken(%q) = %v, want %v", tok, got, want)
+		}
+	}
+}
+
+// TestUserInfoProvider_CallsOutsideLock checks the verifier lock is free
+// while a userinfo call is in flight, so a slow provider can't stall
+// writers (and every reader queued behind them).
+func TestUserInfoProvider_CallsOutsideLock(t *testing.T) {
+	var v *Verifier
+	locked := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/user" {
+			got := make(chan struct{})
+			go func() {
+				v.mu.Lock()
+				v.mu.Unlock()
+				close(got)
+			}()
+			select {
+			case <-got:
+				locked <- false
+			case <-time.After(2 * time.Second):
+				locked <- true
+			}
+		}
+		w.Write([]byte(`{"id":1}`))
+	}))
+	t.Cleanup(srv.Close)
+	v = newGitHubVerifier(t, srv)
+
+	v.VerifyToken(context.Background(), "gho_valid")
+	if <-locked {
+		t.Error("verifier lock held during the userinfo call")
+	}
+}
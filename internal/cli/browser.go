@@ -8,12 +8,16 @@ import (
 	"os"
 	"os/exec"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/brporter/phosphor/internal/clock"
 )
 
 type cliStartResponse struct {
 	SessionID string `json:"session_id"`
+	ExpiresIn int    `json:"expires_in"` // seconds; 0 from relays that predate it
 }
 
 type pollResponse struct {
@@ -23,14 +27,56 @@ type pollResponse struct {
 
 var openBrowserFn = openBrowser
 
+const (
+	defaultPollInterval  = 2 * time.Second
+	defaultMaxPollErrors = 5
+	defaultLoginTimeout  = 5 * time.Minute
+)
+
+// BrowserLoginOptions tunes how BrowserLogin polls the relay. Zero values
+// select the defaults.
+type BrowserLoginOptions struct {
+	// PollInterval is the delay between polls (default 2s). A Retry-After
+	// header on a 429/503 poll response stretches the next delay.
+	PollInterval time.Duration
+	// MaxPollErrors is how many consecutive failed polls are tolerated
+	// before giving up (default 5).
+	MaxPollErrors int
+	// Timeout bounds the whole wait for the user. Zero means the relay's
+	// session lifetime, or 5m from relays that don't report one. An
+	// explicit timeout is still shortened to that lifetime, since polling
+	// past it can never succeed.
+	Timeout time.Duration
+	// Clock drives the poll delays and the timeout (default clock.Real).
+	Clock clock.Clock
+}
+
 // BrowserLogin performs relay-mediated browser-based authentication.
 // The user picks their provider in the browser via the relay's provider-picker page.
 func BrowserLogin(ctx context.Context, relayURL string) (string, error) {
+	return BrowserLoginWithOptions(ctx, relayURL, BrowserLoginOptions{})
+}
+
+// BrowserLoginWithOptions is BrowserLogin with explicit polling options.
+func BrowserLoginWithOptions(ctx context.Context, relayURL string, opts BrowserLoginOptions) (string, error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	if opts.MaxPollErrors <= 0 {
+		opts.MaxPollErrors = defaultMaxPollErrors
+	}
+	clk := clock.OrReal(opts.Clock)
+
 	httpBase := relayURL
 	httpBase = strings.Replace(httpBase, "ws://", "http://", 1)
 	httpBase = strings.Replace(httpBase, "wss://", "https://", 1)
 
-	resp, err := http.Post(httpBase+"/api/auth/cli-start", "application/json", strings.NewReader("{}"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, httpBase+"/api/auth/cli-start", strings.NewReader("{}"))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("start auth session: %w", err)
 	}
@@ -50,6 +96,17 @@ func BrowserLogin(ctx context.Context, relayURL string) (string, error) {
 		return "", fmt.Errorf("decode cli-start response: %w", err)
 	}
 
+	timeout := opts.Timeout
+	expires := time.Duration(startResp.ExpiresIn) * time.Second
+	switch {
+	case timeout <= 0 && expires > 0:
+		timeout = expires
+	case timeout <= 0:
+		timeout = defaultLoginTimeout
+	case expires > 0 && expires < timeout:
+		timeout = expires
+	}
+
 	loginURL := fmt.Sprintf("%s/api/auth/cli-login?session=%s", httpBase, startResp.SessionID)
 
 	fmt.Fprintf(os.Stderr, "\nOpening browser for authentication...\n")
@@ -59,29 +116,71 @@ func BrowserLogin(ctx context.Context, relayURL string) (string, error) {
 	fmt.Fprintf(os.Stderr, "Waiting for authentication...\n")
 	pollURL := fmt.Sprintf("%s/api/auth/poll?session=%s", httpBase, startResp.SessionID)
 
-	deadline := time.Now().Add(5 * time.Minute)
-	for time.Now().Before(deadline) {
+	deadline := clk.After(timeout)
+	delay := opts.PollInterval
+	failures := 0
+	for {
 		select {
 		case <-ctx.Done():
 			return "", ctx.Err()
-		case <-time.After(2 * time.Second):
+		case <-deadline:
+			return "", fmt.Errorf("authentication timed out — please try again")
+		case <-clk.After(delay):
 		}
 
-		pollResp, err := http.Get(pollURL)
+		pr, retryAfter, err := pollOnce(ctx, pollURL)
+		delay = opts.PollInterval
+		if retryAfter > delay {
+			delay = retryAfter
+		}
 		if err != nil {
+			if ctx.Err() != nil {
+				continue // reported by the select above
+			}
+			failures++
+			if failures >= opts.MaxPollErrors {
+				return "", fmt.Errorf("polling for authentication failed %d times: %w", failures, err)
+			}
 			continue
 		}
-
-		var pr pollResponse
-		json.NewDecoder(pollResp.Body).Decode(&pr)
-		pollResp.Body.Close()
+		failures = 0
 
 		if pr.Status == "complete" && pr.IDToken != "" {
 			return pr.IDToken, nil
 		}
 	}
+}
+
+// pollOnce performs a single poll. It returns how long the relay asked us
+// to wait (via Retry-After) alongside any error.
+func pollOnce(ctx context.Context, pollURL string) (pollResponse, time.Duration, error) {
+	var pr pollResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pollURL, nil)
+	if err != nil {
+		return pr, 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return pr, 0, err
+	}
+	defer resp.Body.Close()
 
-	return "", fmt.Errorf("authentication timed out — please try again")
+	if resp.StatusCode != http.StatusOK {
+		var retryAfter time.Duration
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+		// Being throttled is not a failure, just a request to slow down.
+		if resp.StatusCode == http.StatusTooManyRequests {
+			pr.Status = "pending"
+			return pr, retryAfter, nil
+		}
+		return pr, retryAfter, fmt.Errorf("relay returned %d polling auth", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return pr, 0, fmt.Errorf("decode poll response: %w", err)
+	}
+	return pr, 0, nil
 }
 
 func openBrowser(url string) {
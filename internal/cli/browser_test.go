@@ -6,8 +6,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/brporter/phosphor/internal/clock"
 )
 
 func TestBrowserLogin_Success(t *testing.T) {
@@ -98,3 +101,154 @@ func TestBrowserLogin_ContextCanceled(t *testing.T) {
 		t.Fatal("expected error for canceled context, got nil")
 	}
 }
+
+func TestBrowserLogin_PollInterval(t *testing.T) {
+	origOpen := openBrowserFn
+	defer func() { openBrowserFn = origOpen }()
+	openBrowserFn = func(url string) {}
+
+	var mu sync.Mutex
+	pollCount := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/api/auth/cli-start"):
+			json.NewEncoder(w).Encode(cliStartResponse{SessionID: "s1"})
+		case strings.HasSuffix(r.URL.Path, "/api/auth/poll"):
+			mu.Lock()
+			pollCount++
+			n := pollCount
+			mu.Unlock()
+			if n >= 3 {
+				json.NewEncoder(w).Encode(pollResponse{Status: "complete", IDToken: "tok"})
+			} else {
+				json.NewEncoder(w).Encode(pollResponse{Status: "pending"})
+			}
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	token, err := BrowserLoginWithOptions(ctx, srv.URL, BrowserLoginOptions{PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "tok" {
+		t.Errorf("got token %q, want %q", token, "tok")
+	}
+	// Three polls at the 2s default would take 6s.
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("login took %v; configured poll interval not honored", elapsed)
+	}
+}
+
+func TestBrowserLogin_StopsAtDeadline(t *testing.T) {
+	origOpen := openBrowserFn
+	defer func() { openBrowserFn = origOpen }()
+	openBrowserFn = func(url string) {}
+
+	tests := []struct {
+		name      string
+		expiresIn int
+		timeout   time.Duration
+		want      time.Duration
+	}{
+		// The relay forgets the session after 5m, so polling stops there
+		// even though the caller allows 10m.
+		{"relay session lifetime", 300, 10 * time.Minute, 5 * time.Minute},
+		{"configured timeout", 0, 2 * time.Minute, 2 * time.Minute},
+		// Unset, the CLI waits as long as the relay keeps the session.
+		{"relay lifetime when unset", 900, 0, 15 * time.Minute},
+		{"default for older relays", 0, 0, defaultLoginTimeout},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case strings.HasSuffix(r.URL.Path, "/api/auth/cli-start"):
+					json.NewEncoder(w).Encode(cliStartResponse{SessionID: "s1", ExpiresIn: tc.expiresIn})
+				case strings.HasSuffix(r.URL.Path, "/api/auth/poll"):
+					json.NewEncoder(w).Encode(pollResponse{Status: "pending"})
+				}
+			}))
+			defer srv.Close()
+
+			start := time.Unix(0, 0)
+			clk := clock.NewFake(start)
+			done := make(chan error, 1)
+			go func() {
+				_, err := BrowserLoginWithOptions(context.Background(), srv.URL, BrowserLoginOptions{
+					PollInterval: 10 * time.Second,
+					Timeout:      tc.timeout,
+					Clock:        clk,
+				})
+				done <- err
+			}()
+
+			// Step through polls: each wait has the deadline and the next
+			// poll delay pending.
+			for clk.Now().Sub(start) < tc.want {
+				select {
+				case err := <-done:
+					t.Fatalf("gave up after %v: %v", clk.Now().Sub(start), err)
+				default:
+				}
+				clk.BlockUntil(2)
+				clk.Advance(10 * time.Second)
+			}
+			select {
+			case err := <-done:
+				if err == nil || !strings.Contains(err.Error(), "timed out") {
+					t.Fatalf("err = %v, want a timeout", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("still polling %v in", tc.want)
+			}
+		})
+	}
+}
+
+func TestBrowserLogin_SurfacesRepeatedPollFailures(t *testing.T) {
+	origOpen := openBrowserFn
+	defer func() { openBrowserFn = origOpen }()
+	openBrowserFn = func(url string) {}
+
+	var mu sync.Mutex
+	pollCount := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/api/auth/cli-start"):
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(cliStartResponse{SessionID: "s1"})
+		case strings.HasSuffix(r.URL.Path, "/api/auth/poll"):
+			mu.Lock()
+			pollCount++
+			mu.Unlock()
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := BrowserLoginWithOptions(ctx, srv.URL, BrowserLoginOptions{
+		PollInterval:  10 * time.Millisecond,
+		MaxPollErrors: 3,
+	})
+	if err == nil {
+		t.Fatal("expected error after repeated poll failures, got nil")
+	}
+	if !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected error to include the last poll failure, got: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if pollCount != 3 {
+		t.Errorf("polled %d times, want 3", pollCount)
+	}
+}
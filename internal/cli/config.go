@@ -2,6 +2,8 @@ package cli
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 )
@@ -21,6 +23,8 @@ func DefaultConfig() Config {
 	}
 }
 
+const tokenCacheFile = "tokens.json"
+
 // TokenCache stores cached auth tokens.
 type TokenCache struct {
 	AccessToken  string `json:"access_token"`
@@ -28,43 +32,89 @@ type TokenCache struct {
 	Provider     string `json:"provider"`
 }
 
+// configDir returns (and creates) the directory holding CLI state.
+// PHOSPHOR_CONFIG_DIR takes precedence, then $XDG_CONFIG_HOME/phosphor,
+// then ~/.config/phosphor. Releases before XDG support always used
+// ~/.config/phosphor, so while the XDG directory holds no state and that
+// one does, it stays in use rather than stranding an enrolled machine.
 func configDir() (string, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", err
+	dir := os.Getenv("PHOSPHOR_CONFIG_DIR")
+	if dir == "" {
+		home, homeErr := os.UserHomeDir()
+		legacy := filepath.Join(home, ".config", "phosphor")
+		if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+			dir = filepath.Join(xdg, "phosphor")
+			if homeErr == nil && legacy != dir && !hasState(dir) && hasState(legacy) {
+				dir = legacy
+			}
+		} else {
+			if homeErr != nil {
+				return "", homeErr
+			}
+			dir = legacy
+		}
 	}
-	dir := filepath.Join(home, ".config", "phosphor")
 	return dir, os.MkdirAll(dir, 0700)
 }
 
-// LoadTokenCache reads the cached tokens from disk.
+// stateFiles are the files whose presence means a directory is in use.
+var stateFiles = []string{machineKeyFile, machineConfigFile, tokenCacheFile}
+
+func hasState(dir string) bool {
+	for _, name := range stateFiles {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadTokenCache reads the cached tokens from disk. Encrypted caches are
+// opened with the key in TokenCacheKeyEnv; plaintext caches load as-is.
 func LoadTokenCache() (*TokenCache, error) {
 	dir, err := configDir()
 	if err != nil {
 		return nil, err
 	}
-	data, err := os.ReadFile(filepath.Join(dir, "tokens.json"))
+	data, err := os.ReadFile(filepath.Join(dir, tokenCacheFile))
 	if err != nil {
 		return nil, err
 	}
-	var cache TokenCache
-	if err := json.Unmarshal(data, &cache); err != nil {
-		return nil, err
-	}
-	return &cache, nil
+	return openTokenCache(data, []byte(os.Getenv(TokenCacheKeyEnv)))
 }
 
-// SaveTokenCache writes tokens to disk with restricted permissions.
+// SaveTokenCache writes tokens to disk with restricted permissions,
+// encrypted when TokenCacheKeyEnv is set.
 func SaveTokenCache(cache *TokenCache) error {
-	dir, err := configDir()
+	if secret := os.Getenv(TokenCacheKeyEnv); secret != "" {
+		return SaveTokenCacheEncrypted(cache, []byte(secret))
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
 	if err != nil {
 		return err
 	}
-	data, err := json.MarshalIndent(cache, "", "  ")
+	return writeTokenCache(data)
+}
+
+// SaveTokenCacheEncrypted writes tokens encrypted under a key derived from
+// secret.
+func SaveTokenCacheEncrypted(cache *TokenCache, secret []byte) error {
+	if len(secret) == 0 {
+		return errors.New("token cache secret is empty")
+	}
+	data, err := sealTokenCache(cache, secret)
+	if err != nil {
+		return fmt.Errorf("encrypting token cache: %w", err)
+	}
+	return writeTokenCache(data)
+}
+
+func writeTokenCache(data []byte) error {
+	dir, err := configDir()
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filepath.Join(dir, "tokens.json"), data, 0600)
+	return os.WriteFile(filepath.Join(dir, tokenCacheFile), data, 0600)
 }
 
 // ClearTokenCache removes the cached tokens.
@@ -73,7 +123,7 @@ func ClearTokenCache() error {
 	if err != nil {
 		return err
 	}
-	err = os.Remove(filepath.Join(dir, "tokens.json"))
+	err = os.Remove(filepath.Join(dir, tokenCacheFile))
 	if os.IsNotExist(err) {
 		return nil
 	}
@@ -1,6 +1,11 @@
 package cli
 
 import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -11,10 +16,74 @@ func TestDefaultConfig(t *testing.T) {
 	}
 }
 
+func TestConfigDir_Precedence(t *testing.T) {
+	home := t.TempDir()
+	xdg := t.TempDir()
+	override := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	tests := []struct {
+		name     string
+		xdg      string
+		override string
+		want     string
+	}{
+		{"home default", "", "", filepath.Join(home, ".config", "phosphor")},
+		{"xdg", xdg, "", filepath.Join(xdg, "phosphor")},
+		{"explicit override", xdg, override, override},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", tc.xdg)
+			t.Setenv("PHOSPHOR_CONFIG_DIR", tc.override)
+
+			got, err := configDir()
+			if err != nil {
+				t.Fatalf("configDir: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("configDir = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigDir_KeepsLegacyStateUnderXDG(t *testing.T) {
+	home := t.TempDir()
+	xdg := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("XDG_CONFIG_HOME", xdg)
+	t.Setenv("PHOSPHOR_CONFIG_DIR", "")
+
+	// A machine enrolled by a release that ignored XDG_CONFIG_HOME.
+	legacy := filepath.Join(home, ".config", "phosphor")
+	if err := os.MkdirAll(legacy, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacy, machineConfigFile), []byte(`{"machine_id":"m1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := configDir(); err != nil || got != legacy {
+		t.Fatalf("configDir = %q, %v; want the legacy directory %q", got, err, legacy)
+	}
+
+	// Once the XDG directory holds state of its own, it wins.
+	current := filepath.Join(xdg, "phosphor")
+	if err := os.MkdirAll(current, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(current, tokenCacheFile), []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := configDir(); err != nil || got != current {
+		t.Errorf("configDir = %q, %v; want %q", got, err, current)
+	}
+}
+
 func TestSaveAndLoadTokenCache(t *testing.T) {
-	tmpDir := t.TempDir()
-	t.Setenv("USERPROFILE", tmpDir)
-	t.Setenv("HOME", tmpDir)
+	t.Setenv("PHOSPHOR_CONFIG_DIR", t.TempDir())
 
 	cache := &TokenCache{
 		AccessToken:  "access-token-value",
@@ -43,9 +112,7 @@ func TestSaveAndLoadTokenCache(t *testing.T) {
 }
 
 func TestLoadTokenCache_NotExists(t *testing.T) {
-	tmpDir := t.TempDir()
-	t.Setenv("USERPROFILE", tmpDir)
-	t.Setenv("HOME", tmpDir)
+	t.Setenv("PHOSPHOR_CONFIG_DIR", t.TempDir())
 
 	_, err := LoadTokenCache()
 	if err == nil {
@@ -54,9 +121,7 @@ func TestLoadTokenCache_NotExists(t *testing.T) {
 }
 
 func TestClearTokenCache(t *testing.T) {
-	tmpDir := t.TempDir()
-	t.Setenv("USERPROFILE", tmpDir)
-	t.Setenv("HOME", tmpDir)
+	t.Setenv("PHOSPHOR_CONFIG_DIR", t.TempDir())
 
 	cache := &TokenCache{
 		AccessToken: "token",
@@ -78,11 +143,105 @@ func TestClearTokenCache(t *testing.T) {
 }
 
 func TestClearTokenCache_NotExists(t *testing.T) {
-	tmpDir := t.TempDir()
-	t.Setenv("USERPROFILE", tmpDir)
-	t.Setenv("HOME", tmpDir)
+	t.Setenv("PHOSPHOR_CONFIG_DIR", t.TempDir())
 
 	if err := ClearTokenCache(); err != nil {
 		t.Errorf("ClearTokenCache on missing file should not error, got: %v", err)
 	}
 }
+
+func TestTokenCache_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PHOSPHOR_CONFIG_DIR", dir)
+	t.Setenv(TokenCacheKeyEnv, "machine-secret")
+
+	cache := &TokenCache{AccessToken: "access-token-value", RefreshToken: "refresh-token-value", Provider: "google"}
+	if err := SaveTokenCache(cache); err != nil {
+		t.Fatalf("SaveTokenCache: %v", err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "access-token-value") || strings.Contains(string(raw), "refresh-token-value") {
+		t.Fatalf("tokens stored in plaintext:\n%s", raw)
+	}
+
+	loaded, err := LoadTokenCache()
+	if err != nil {
+		t.Fatalf("LoadTokenCache: %v", err)
+	}
+	if *loaded != *cache {
+		t.Errorf("loaded %+v, want %+v", loaded, cache)
+	}
+
+	t.Setenv(TokenCacheKeyEnv, "")
+	if _, err := LoadTokenCache(); !errors.Is(err, ErrTokenCacheLocked) {
+		t.Errorf("without key: err = %v, want ErrTokenCacheLocked", err)
+	}
+	t.Setenv(TokenCacheKeyEnv, "wrong-secret")
+	if _, err := LoadTokenCache(); err == nil {
+		t.Error("loaded an encrypted cache with the wrong key")
+	}
+}
+
+func TestTokenCache_EnvelopeUsesScrypt(t *testing.T) {
+	data, err := sealTokenCache(&TokenCache{AccessToken: "tok"}, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env encryptedTokenCache
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != tokenCacheVersion || env.N != scryptN || env.R != scryptR || env.P != scryptP {
+		t.Errorf("envelope = version %d N=%d r=%d p=%d, want scrypt parameters recorded", env.Version, env.N, env.R, env.P)
+	}
+
+	// A file can't make loading arbitrarily expensive.
+	env.N = scryptMaxN * 2
+	tampered, _ := json.Marshal(env)
+	if _, err := openTokenCache(tampered, []byte("hunter2")); err == nil || !strings.Contains(err.Error(), "too costly") {
+		t.Errorf("oversized scrypt parameters: err = %v", err)
+	}
+}
+
+func TestTokenCache_LoadsVersion1(t *testing.T) {
+	secret := []byte("machine-secret")
+	env := encryptedTokenCache{Version: tokenCacheVersionHKDF, Salt: []byte("0123456789abcdef")}
+	aead, err := tokenCacheAEAD(secret, &env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Nonce = make([]byte, aead.NonceSize())
+	env.Ciphertext = aead.Seal(nil, env.Nonce, []byte(`{"access_token":"v1-token"}`), nil)
+	data, _ := json.Marshal(env)
+
+	loaded, err := openTokenCache(data, secret)
+	if err != nil {
+		t.Fatalf("openTokenCache: %v", err)
+	}
+	if loaded.AccessToken != "v1-token" {
+		t.Errorf("loaded %+v", loaded)
+	}
+}
+
+func TestTokenCache_LoadsLegacyPlaintext(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PHOSPHOR_CONFIG_DIR", dir)
+	// Setting a key later must not strand a cache written before it.
+	t.Setenv(TokenCacheKeyEnv, "machine-secret")
+
+	legacy := `{"access_token": "old-token", "refresh_token": "", "provider": "microsoft"}`
+	if err := os.WriteFile(filepath.Join(dir, "tokens.json"), []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadTokenCache()
+	if err != nil {
+		t.Fatalf("LoadTokenCache: %v", err)
+	}
+	if loaded.AccessToken != "old-token" || loaded.Provider != "microsoft" {
+		t.Errorf("loaded %+v", loaded)
+	}
+}
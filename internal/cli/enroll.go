@@ -19,6 +19,9 @@ type EnrollOptions struct {
 	Name     string // defaults to hostname
 	APIKey   string // "phk:..." for headless enrollment; browser login if empty
 	SSHDAddr string // local sshd the tunnel will expose (default 127.0.0.1:22)
+	// LoginTimeout bounds the browser login, when one is needed (see
+	// BrowserLoginOptions.Timeout).
+	LoginTimeout time.Duration
 }
 
 type sshInfoResponse struct {
@@ -54,7 +57,7 @@ func Enroll(ctx context.Context, opts EnrollOptions) (*MachineConfig, error) {
 	}
 	if token == "" {
 		var err error
-		token, err = BrowserLogin(ctx, opts.RelayURL)
+		token, err = BrowserLoginWithOptions(ctx, opts.RelayURL, BrowserLoginOptions{Timeout: opts.LoginTimeout})
 		if err != nil {
 			return nil, fmt.Errorf("authenticating: %w", err)
 		}
@@ -2,9 +2,13 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/brporter/phosphor/internal/auth"
 )
@@ -14,26 +18,44 @@ var deviceCodeConfigs = map[string]struct {
 	DeviceAuthURL string
 	TokenURL      string
 	ClientIDEnv   string
+	ScopesEnv     string // overrides Scopes when set
 	Scopes        []string
 }{
 	"microsoft": {
 		DeviceAuthURL: "https://login.microsoftonline.com/common/oauth2/v2.0/devicecode",
 		TokenURL:      "https://login.microsoftonline.com/common/oauth2/v2.0/token",
 		ClientIDEnv:   "PHOSPHOR_MICROSOFT_CLIENT_ID",
+		ScopesEnv:     "PHOSPHOR_MICROSOFT_SCOPES",
 		Scopes:        []string{"openid", "profile", "email", "offline_access"},
 	},
 	"google": {
 		DeviceAuthURL: "https://oauth2.googleapis.com/device/code",
 		TokenURL:      "https://oauth2.googleapis.com/token",
 		ClientIDEnv:   "PHOSPHOR_GOOGLE_CLIENT_ID",
+		ScopesEnv:     "PHOSPHOR_GOOGLE_SCOPES",
 		Scopes:        []string{"openid", "profile", "email"},
 	},
 }
 
-var supportedProviders = []string{"apple", "microsoft", "google"}
+var supportedProviders = []string{"apple", "microsoft", "google", "github"}
 
-// Login performs authentication for the given provider.
-func Login(ctx context.Context, providerName, relayURL string, useDeviceCode bool) error {
+// ErrDeviceCodeUnsupported is returned when --device-code is used with a
+// provider that has no device code flow configured.
+var ErrDeviceCodeUnsupported = errors.New("device code flow not supported")
+
+// deviceCodeProviders lists the providers in deviceCodeConfigs, sorted.
+func deviceCodeProviders() []string {
+	names := make([]string, 0, len(deviceCodeConfigs))
+	for name := range deviceCodeConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Login performs authentication for the given provider. browserTimeout
+// bounds the browser flow's wait (see BrowserLoginOptions.Timeout).
+func Login(ctx context.Context, providerName, relayURL string, useDeviceCode bool, browserTimeout time.Duration) error {
 	providerName = strings.ToLower(providerName)
 
 	if useDeviceCode {
@@ -50,7 +72,7 @@ func Login(ctx context.Context, providerName, relayURL string, useDeviceCode boo
 		return loginDeviceCode(ctx, providerName)
 	}
 
-	token, err := BrowserLogin(ctx, relayURL)
+	token, err := BrowserLoginWithOptions(ctx, relayURL, BrowserLoginOptions{Timeout: browserTimeout})
 	if err != nil {
 		return fmt.Errorf("browser login: %w", err)
 	}
@@ -68,7 +90,8 @@ func Login(ctx context.Context, providerName, relayURL string, useDeviceCode boo
 func loginDeviceCode(ctx context.Context, providerName string) error {
 	p, ok := deviceCodeConfigs[providerName]
 	if !ok {
-		return fmt.Errorf("device code flow not supported for %s — use browser login instead", providerName)
+		return fmt.Errorf("%w for %s (available for: %s); run `phosphor login` without --device-code to sign in through the browser",
+			ErrDeviceCodeUnsupported, providerName, strings.Join(deviceCodeProviders(), ", "))
 	}
 
 	clientID := os.Getenv(p.ClientIDEnv)
@@ -76,13 +99,12 @@ func loginDeviceCode(ctx context.Context, providerName string) error {
 		return fmt.Errorf("no client ID configured — set %s environment variable", p.ClientIDEnv)
 	}
 
-	dcr, err := auth.RequestDeviceCode(ctx, p.DeviceAuthURL, clientID, p.Scopes)
+	dcr, err := auth.RequestDeviceCode(ctx, p.DeviceAuthURL, clientID, deviceScopes(p.ScopesEnv, p.Scopes))
 	if err != nil {
 		return fmt.Errorf("request device code: %w", err)
 	}
 
-	fmt.Fprintf(os.Stderr, "\nTo sign in, visit: %s\n", dcr.VerificationURI)
-	fmt.Fprintf(os.Stderr, "Enter code: %s\n\n", dcr.UserCode)
+	printDeviceCodeInstructions(os.Stderr, dcr)
 	fmt.Fprintf(os.Stderr, "Waiting for authentication...\n")
 
 	dtr, err := auth.PollForToken(ctx, p.TokenURL, clientID, dcr.DeviceCode)
@@ -90,9 +112,9 @@ func loginDeviceCode(ctx context.Context, providerName string) error {
 		return fmt.Errorf("authentication failed: %w", err)
 	}
 
-	token := dtr.IDToken
-	if token == "" {
-		token = dtr.AccessToken
+	token, err := deviceToken(os.Stderr, providerName, dtr)
+	if err != nil {
+		return err
 	}
 
 	if err := SaveTokenCache(&TokenCache{
@@ -106,3 +128,43 @@ func loginDeviceCode(ctx context.Context, providerName string) error {
 	fmt.Fprintf(os.Stderr, "Authenticated successfully!\n")
 	return nil
 }
+
+// deviceScopes returns the space- or comma-separated scopes in the env var,
+// or def when it is unset, so operators can request e.g. offline_access
+// without a rebuild.
+func deviceScopes(env string, def []string) []string {
+	if scopes := strings.Fields(strings.ReplaceAll(os.Getenv(env), ",", " ")); len(scopes) > 0 {
+		return scopes
+	}
+	return def
+}
+
+// deviceToken picks the credential to cache from a device code token
+// response. The relay verifies ID tokens, so an access token is only a
+// fallback, and the user is warned that the relay may reject it.
+func deviceToken(w io.Writer, providerName string, dtr *auth.DeviceTokenResponse) (string, error) {
+	if dtr.IDToken != "" {
+		return dtr.IDToken, nil
+	}
+	if dtr.AccessToken == "" {
+		return "", fmt.Errorf("%s returned neither an ID token nor an access token", providerName)
+	}
+	fmt.Fprintf(w, "Warning: %s returned no ID token; using its access token, which the relay may not accept.\n", providerName)
+	return dtr.AccessToken, nil
+}
+
+// printDeviceCodeInstructions tells the user how to approve the device
+// code. When the provider supplies a verification URL with the code
+// pre-filled, that URL is opened in the browser; the code is still printed
+// so the user can check it matches.
+func printDeviceCodeInstructions(w io.Writer, dcr *auth.DeviceCodeResponse) {
+	if dcr.VerificationURIComplete != "" {
+		fmt.Fprintf(w, "\nOpening browser to sign in...\n")
+		fmt.Fprintf(w, "If the browser doesn't open, visit: %s\n", dcr.VerificationURIComplete)
+		fmt.Fprintf(w, "Confirm the code shown is: %s\n\n", dcr.UserCode)
+		openBrowserFn(dcr.VerificationURIComplete)
+		return
+	}
+	fmt.Fprintf(w, "\nTo sign in, visit: %s\n", dcr.VerificationURI)
+	fmt.Fprintf(w, "Enter code: %s\n\n", dcr.UserCode)
+}
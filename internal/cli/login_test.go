@@ -1,14 +1,18 @@
 package cli
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"strings"
 	"testing"
+
+	"github.com/brporter/phosphor/internal/auth"
 )
 
 func TestLogin_InvalidProviderDeviceCode(t *testing.T) {
 	ctx := context.Background()
-	err := Login(ctx, "invalid", "ws://localhost", true)
+	err := Login(ctx, "invalid", "ws://localhost", true, 0)
 	if err == nil {
 		t.Fatal("expected error for invalid provider, got nil")
 	}
@@ -20,12 +24,64 @@ func TestLogin_InvalidProviderDeviceCode(t *testing.T) {
 func TestLoginDeviceCode_Unsupported(t *testing.T) {
 	ctx := context.Background()
 	// apple is a valid provider name but device code flow is not supported for it
-	err := Login(ctx, "apple", "ws://localhost", true)
+	err := Login(ctx, "apple", "ws://localhost", true, 0)
 	if err == nil {
 		t.Fatal("expected error for unsupported device code provider, got nil")
 	}
-	if !strings.Contains(err.Error(), "not supported") {
-		t.Errorf("expected error to contain %q, got: %v", "not supported", err)
+	if !errors.Is(err, ErrDeviceCodeUnsupported) {
+		t.Errorf("expected ErrDeviceCodeUnsupported, got: %v", err)
+	}
+	// The message should point the user somewhere useful.
+	for _, want := range []string{"apple", "google, microsoft", "without --device-code"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestLoginDeviceCode_UnsupportedGitHub(t *testing.T) {
+	err := Login(context.Background(), "github", "ws://localhost", true, 0)
+	if !errors.Is(err, ErrDeviceCodeUnsupported) {
+		t.Errorf("expected ErrDeviceCodeUnsupported, got: %v", err)
+	}
+}
+
+func TestDeviceToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		dtr      auth.DeviceTokenResponse
+		want     string
+		wantErr  bool
+		wantWarn bool
+	}{
+		{"id token preferred", auth.DeviceTokenResponse{IDToken: "id", AccessToken: "at"}, "id", false, false},
+		{"access token fallback", auth.DeviceTokenResponse{AccessToken: "at"}, "at", false, true},
+		{"neither", auth.DeviceTokenResponse{}, "", true, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			got, err := deviceToken(&buf, "google", &tc.dtr)
+			if (err != nil) != tc.wantErr || got != tc.want {
+				t.Fatalf("deviceToken = %q, %v", got, err)
+			}
+			if warned := strings.Contains(buf.String(), "Warning"); warned != tc.wantWarn {
+				t.Errorf("warning printed = %v, want %v (%q)", warned, tc.wantWarn, buf.String())
+			}
+		})
+	}
+}
+
+func TestDeviceScopes(t *testing.T) {
+	def := []string{"openid", "email"}
+
+	t.Setenv("PHOSPHOR_TEST_SCOPES", "")
+	if got := deviceScopes("PHOSPHOR_TEST_SCOPES", def); strings.Join(got, " ") != "openid email" {
+		t.Errorf("unset: scopes = %v, want defaults", got)
+	}
+	t.Setenv("PHOSPHOR_TEST_SCOPES", "openid, email offline_access")
+	if got := deviceScopes("PHOSPHOR_TEST_SCOPES", def); strings.Join(got, " ") != "openid email offline_access" {
+		t.Errorf("set: scopes = %v", got)
 	}
 }
 
@@ -33,7 +89,7 @@ func TestLoginDeviceCode_MissingClientID(t *testing.T) {
 	t.Setenv("PHOSPHOR_MICROSOFT_CLIENT_ID", "")
 
 	ctx := context.Background()
-	err := Login(ctx, "microsoft", "ws://localhost", true)
+	err := Login(ctx, "microsoft", "ws://localhost", true, 0)
 	if err == nil {
 		t.Fatal("expected error for missing client ID, got nil")
 	}
@@ -41,3 +97,44 @@ func TestLoginDeviceCode_MissingClientID(t *testing.T) {
 		t.Errorf("expected error to contain %q, got: %v", "no client ID", err)
 	}
 }
+
+func TestPrintDeviceCodeInstructions_PrefersCompleteURI(t *testing.T) {
+	origOpen := openBrowserFn
+	defer func() { openBrowserFn = origOpen }()
+	var opened string
+	openBrowserFn = func(url string) { opened = url }
+
+	var out strings.Builder
+	printDeviceCodeInstructions(&out, &auth.DeviceCodeResponse{
+		UserCode:                "ABCD-EFGH",
+		VerificationURI:         "https://example.com/device",
+		VerificationURIComplete: "https://example.com/device?code=ABCD-EFGH",
+	})
+
+	if opened != "https://example.com/device?code=ABCD-EFGH" {
+		t.Errorf("opened %q, want the complete verification URI", opened)
+	}
+	if !strings.Contains(out.String(), "https://example.com/device?code=ABCD-EFGH") {
+		t.Errorf("output %q does not show the complete verification URI", out.String())
+	}
+}
+
+func TestPrintDeviceCodeInstructions_FallsBackToUserCode(t *testing.T) {
+	origOpen := openBrowserFn
+	defer func() { openBrowserFn = origOpen }()
+	opened := false
+	openBrowserFn = func(url string) { opened = true }
+
+	var out strings.Builder
+	printDeviceCodeInstructions(&out, &auth.DeviceCodeResponse{
+		UserCode:        "ABCD-EFGH",
+		VerificationURI: "https://example.com/device",
+	})
+
+	if opened {
+		t.Error("browser opened without a complete verification URI")
+	}
+	if !strings.Contains(out.String(), "https://example.com/device") || !strings.Contains(out.String(), "Enter code: ABCD-EFGH") {
+		t.Errorf("output %q missing verification URI or user code", out.String())
+	}
+}
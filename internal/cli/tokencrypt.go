@@ -0,0 +1,136 @@
+package cli
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// TokenCacheKeyEnv names the env var holding the secret that encrypts the
+// token cache at rest. Set it from a machine-local secret store (a keyring
+// helper, a systemd credential) rather than a file next to the cache.
+const TokenCacheKeyEnv = "PHOSPHOR_TOKEN_CACHE_KEY"
+
+// tokenCacheVersion is the current encrypted cache format: the key comes
+// from scrypt, since the secret may be a passphrase a stolen cache could be
+// brute-forced against. Version 1 used HKDF and is still read. Plaintext
+// caches written before encryption existed have no version field.
+const (
+	tokenCacheVersion     = 2
+	tokenCacheVersionHKDF = 1
+)
+
+// scrypt cost for new caches (32 MiB, ~50ms a load), and the most a cache
+// file may demand (256 MiB), since the parameters come from the file.
+const (
+	scryptN    = 1 << 15
+	scryptR    = 8
+	scryptP    = 1
+	scryptMaxN = 1 << 17
+	scryptMaxR = 16
+	scryptMaxP = 4
+)
+
+// ErrTokenCacheLocked is returned when the cache on disk is encrypted but
+// no key is available to open it.
+var ErrTokenCacheLocked = errors.New("token cache is encrypted; set " + TokenCacheKeyEnv)
+
+// encryptedTokenCache is the on-disk envelope of an encrypted cache: the
+// JSON TokenCache sealed with AES-256-GCM under a key derived from the
+// secret and Salt with scrypt(N, R, P).
+type encryptedTokenCache struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	N          int    `json:"n,omitempty"`
+	R          int    `json:"r,omitempty"`
+	P          int    `json:"p,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// tokenCacheAEAD derives env's cipher from secret.
+func tokenCacheAEAD(secret []byte, env *encryptedTokenCache) (cipher.AEAD, error) {
+	var key []byte
+	var err error
+	switch env.Version {
+	case tokenCacheVersionHKDF:
+		key, err = hkdf.Key(sha256.New, secret, env.Salt, "phosphor token cache v1", 32)
+	default:
+		if env.N > scryptMaxN || env.R > scryptMaxR || env.P > scryptMaxP {
+			return nil, fmt.Errorf("token cache scrypt parameters too costly (N=%d r=%d p=%d)", env.N, env.R, env.P)
+		}
+		key, err = scrypt.Key(secret, env.Salt, env.N, env.R, env.P, 32)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealTokenCache(cache *TokenCache, secret []byte) ([]byte, error) {
+	plain, err := json.Marshal(cache)
+	if err != nil {
+		return nil, err
+	}
+	env := encryptedTokenCache{Version: tokenCacheVersion, Salt: make([]byte, 16), N: scryptN, R: scryptR, P: scryptP}
+	if _, err := rand.Read(env.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := tokenCacheAEAD(secret, &env)
+	if err != nil {
+		return nil, err
+	}
+	env.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return nil, err
+	}
+	env.Ciphertext = aead.Seal(nil, env.Nonce, plain, nil)
+	return json.MarshalIndent(env, "", "  ")
+}
+
+// openTokenCache decodes a cache file in either format. secret may be nil
+// for plaintext caches.
+func openTokenCache(data, secret []byte) (*TokenCache, error) {
+	var env encryptedTokenCache
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	var plain []byte
+	switch env.Version {
+	case 0:
+		plain = data // legacy plaintext cache
+	case tokenCacheVersionHKDF, tokenCacheVersion:
+		if len(secret) == 0 {
+			return nil, ErrTokenCacheLocked
+		}
+		aead, err := tokenCacheAEAD(secret, &env)
+		if err != nil {
+			return nil, err
+		}
+		if len(env.Nonce) != aead.NonceSize() {
+			return nil, errors.New("decrypting token cache: bad nonce")
+		}
+		plain, err = aead.Open(nil, env.Nonce, env.Ciphertext, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting token cache (wrong %s?): %w", TokenCacheKeyEnv, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported token cache version %d", env.Version)
+	}
+	var cache TokenCache
+	if err := json.Unmarshal(plain, &cache); err != nil {
+		return nil, err
+	}
+	return &cache, nil
+}
@@ -6,27 +6,106 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"math/rand"
 	"net"
 	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+
+	"github.com/brporter/phosphor/internal/clock"
 )
 
 const (
 	keepaliveInterval = 30 * time.Second
 	keepaliveTimeout  = 15 * time.Second
-	maxBackoff        = 60 * time.Second
 	defaultSSHDAddr   = "127.0.0.1:22"
 )
 
+// Backoff is the reconnect schedule: the delay before attempt n is
+// Initial*Multiplier^n capped at Max, then spread by ±Jitter of itself.
+// A tunnel that stayed up for at least ResetAfter counts as healthy: the
+// schedule starts over and the first reconnect is immediate, since a relay
+// redeploy is usually back by then. Zero fields take the DefaultBackoff*
+// values; a negative Jitter disables jitter.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+	ResetAfter time.Duration
+}
+
+// Backoff defaults, shared with the CLI flags so --help shows what a zero
+// field means.
+const (
+	DefaultBackoffInitial    = time.Second
+	DefaultBackoffMax        = 60 * time.Second
+	DefaultBackoffMultiplier = 2.0
+	DefaultBackoffJitter     = 0.5
+	DefaultBackoffResetAfter = 30 * time.Second
+)
+
+func (b Backoff) withDefaults() Backoff {
+	if b.Initial <= 0 {
+		b.Initial = DefaultBackoffInitial
+	}
+	if b.Max <= 0 {
+		b.Max = DefaultBackoffMax
+	}
+	if b.Max < b.Initial {
+		b.Max = b.Initial
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = DefaultBackoffMultiplier
+	}
+	if b.ResetAfter <= 0 {
+		b.ResetAfter = DefaultBackoffResetAfter
+	}
+	switch {
+	case b.Jitter == 0:
+		b.Jitter = DefaultBackoffJitter
+	case b.Jitter < 0:
+		b.Jitter = 0
+	case b.Jitter > 1:
+		b.Jitter = 1
+	}
+	return b
+}
+
+// nextBackoff returns the delay before reconnect attempt (0-based) under a
+// schedule already filled in by withDefaults.
+func nextBackoff(b Backoff, attempt int) time.Duration {
+	base := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if base > float64(b.Max) {
+		base = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		base *= 1 + b.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(base)
+}
+
+// reconnectDelay decides how long to wait before reconnecting after a tunnel
+// that stayed established for up (zero if it never came up), given the
+// number of consecutive attempts so far. It returns the delay and the
+// attempt count for the next call.
+func reconnectDelay(b Backoff, attempt int, up time.Duration) (time.Duration, int) {
+	if up >= b.ResetAfter {
+		return 0, 0
+	}
+	return nextBackoff(b, attempt), attempt + 1
+}
+
 // TunnelOptions configures the reverse tunnel loop.
 type TunnelOptions struct {
 	Machine  *MachineConfig
 	Signer   ssh.Signer
 	Logger   *slog.Logger
 	SSHDAddr string // overrides Machine.SSHDAddr
+	Backoff  Backoff
+	Clock    clock.Clock // default clock.Real
 }
 
 // RunTunnel maintains a reverse tunnel to the gateway until ctx is
@@ -47,32 +126,32 @@ func RunTunnel(ctx context.Context, opts TunnelOptions) error {
 		return fmt.Errorf("parsing pinned gateway host key: %w", err)
 	}
 
-	backoff := time.Second
+	opts.Clock = clock.OrReal(opts.Clock)
+	backoff := opts.Backoff.withDefaults()
+	attempt := 0
 	for {
-		err := runTunnelOnce(ctx, opts, hostKey, sshdAddr)
+		up, err := runTunnelOnce(ctx, opts, hostKey, sshdAddr)
 		if ctx.Err() != nil {
 			return nil
 		}
 		if err != nil {
-			opts.Logger.Warn("tunnel disconnected", "err", err)
+			opts.Logger.Warn("tunnel disconnected", "err", err, "up", up.Round(time.Second))
 		}
 
-		// Jittered exponential backoff.
-		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		var delay time.Duration
+		delay, attempt = reconnectDelay(backoff, attempt, up)
 		opts.Logger.Info("reconnecting", "in", delay.Round(time.Millisecond))
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-time.After(delay):
-		}
-		backoff *= 2
-		if backoff > maxBackoff {
-			backoff = maxBackoff
+		case <-opts.Clock.After(delay):
 		}
 	}
 }
 
-func runTunnelOnce(ctx context.Context, opts TunnelOptions, hostKey ssh.PublicKey, sshdAddr string) error {
+// runTunnelOnce holds one tunnel connection open until it drops, returning
+// how long it was established (zero if it never was).
+func runTunnelOnce(ctx context.Context, opts TunnelOptions, hostKey ssh.PublicKey, sshdAddr string) (up time.Duration, err error) {
 	cfg := &ssh.ClientConfig{
 		User:            opts.Machine.MachineID,
 		Auth:            []ssh.AuthMethod{ssh.PublicKeys(opts.Signer)},
@@ -82,7 +161,7 @@ func runTunnelOnce(ctx context.Context, opts TunnelOptions, hostKey ssh.PublicKe
 
 	client, err := ssh.Dial("tcp", opts.Machine.SSHAddr, cfg)
 	if err != nil {
-		return fmt.Errorf("dialing gateway %s: %w", opts.Machine.SSHAddr, err)
+		return 0, fmt.Errorf("dialing gateway %s: %w", opts.Machine.SSHAddr, err)
 	}
 	defer client.Close()
 
@@ -90,11 +169,14 @@ func runTunnelOnce(ctx context.Context, opts TunnelOptions, hostKey ssh.PublicKe
 	// it back when opening forwarded-tcpip channels.
 	listener, err := client.Listen("tcp", "0.0.0.0:22")
 	if err != nil {
-		return fmt.Errorf("requesting reverse forward: %w", err)
+		return 0, fmt.Errorf("requesting reverse forward: %w", err)
 	}
 	defer listener.Close()
 
 	opts.Logger.Info("tunnel established", "gateway", opts.Machine.SSHAddr, "exposing", sshdAddr)
+	// From here on every return reports the uptime, whatever it says.
+	established := opts.Clock.Now()
+	defer func() { up = opts.Clock.Now().Sub(established) }()
 
 	connCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -102,13 +184,13 @@ func runTunnelOnce(ctx context.Context, opts TunnelOptions, hostKey ssh.PublicKe
 	// Keepalives detect dead gateways; a missed reply tears the tunnel down
 	// so the backoff loop can rebuild it.
 	go func() {
-		ticker := time.NewTicker(keepaliveInterval)
+		ticker := opts.Clock.NewTicker(keepaliveInterval)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-connCtx.Done():
 				return
-			case <-ticker.C:
+			case <-ticker.C():
 				done := make(chan error, 1)
 				go func() {
 					_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
@@ -121,7 +203,7 @@ func runTunnelOnce(ctx context.Context, opts TunnelOptions, hostKey ssh.PublicKe
 						client.Close()
 						return
 					}
-				case <-time.After(keepaliveTimeout):
+				case <-opts.Clock.After(keepaliveTimeout):
 					opts.Logger.Debug("keepalive timed out")
 					client.Close()
 					return
@@ -143,9 +225,9 @@ func runTunnelOnce(ctx context.Context, opts TunnelOptions, hostKey ssh.PublicKe
 		if err != nil {
 			wg.Wait()
 			if errors.Is(err, io.EOF) || connCtx.Err() != nil {
-				return nil
+				return 0, nil
 			}
-			return err
+			return 0, err
 		}
 		wg.Add(1)
 		go func() {
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextBackoff_Defaults(t *testing.T) {
+	b := Backoff{Jitter: -1}.withDefaults()
+	want := []time.Duration{
+		1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, 32 * time.Second, 60 * time.Second, 60 * time.Second,
+	}
+	for attempt, w := range want {
+		if got := nextBackoff(b, attempt); got != w {
+			t.Errorf("attempt %d: got %v, want %v", attempt, got, w)
+		}
+	}
+}
+
+func TestNextBackoff_Multiplier(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Multiplier: 3, Jitter: -1}.withDefaults()
+	if got, want := nextBackoff(b, 2), 900*time.Millisecond; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNextBackoff_Cap(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 5 * time.Second, Jitter: -1}.withDefaults()
+	if got := nextBackoff(b, 1000); got != 5*time.Second {
+		t.Errorf("got %v, want cap of 5s", got)
+	}
+}
+
+func TestNextBackoff_JitterBounds(t *testing.T) {
+	b := Backoff{Initial: 10 * time.Second, Jitter: 0.2}.withDefaults()
+	lo, hi := 8*time.Second, 12*time.Second
+	for i := 0; i < 1000; i++ {
+		if got := nextBackoff(b, 0); got < lo || got > hi {
+			t.Fatalf("delay %v outside [%v, %v]", got, lo, hi)
+		}
+	}
+}
+
+func TestReconnectDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 8 * time.Second, Jitter: -1, ResetAfter: 30 * time.Second}.withDefaults()
+
+	// Never connected: the schedule escalates and the counter advances.
+	attempt := 0
+	for _, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second} {
+		var got time.Duration
+		got, attempt = reconnectDelay(b, attempt, 0)
+		if got != want {
+			t.Fatalf("attempt %d: delay %v, want %v", attempt, got, want)
+		}
+	}
+
+	// A brief connection (flapping) doesn't reset the schedule.
+	if got, next := reconnectDelay(b, attempt, 5*time.Second); got != 8*time.Second || next != attempt+1 {
+		t.Errorf("short-lived tunnel: delay %v next %d", got, next)
+	}
+
+	// A stable connection retries immediately and starts over.
+	got, attempt := reconnectDelay(b, attempt, time.Minute)
+	if got != 0 || attempt != 0 {
+		t.Fatalf("stable tunnel: delay %v next %d, want 0 and 0", got, attempt)
+	}
+	// If that fast retry fails, the schedule resumes from the beginning.
+	if got, next := reconnectDelay(b, attempt, 0); got != time.Second || next != 1 {
+		t.Errorf("after fast retry: delay %v next %d, want 1s and 1", got, next)
+	}
+}
+
+func TestBackoff_ResetAfterDefault(t *testing.T) {
+	if got := (Backoff{}).withDefaults().ResetAfter; got != 30*time.Second {
+		t.Errorf("ResetAfter default = %v, want 30s", got)
+	}
+}
@@ -0,0 +1,74 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func fired(ch <-chan time.Time) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFake_After(t *testing.T) {
+	f := NewFake(epoch)
+	ch := f.After(time.Minute)
+
+	f.Advance(59 * time.Second)
+	if fired(ch) {
+		t.Fatal("fired early")
+	}
+	f.Advance(time.Second)
+	if !fired(ch) {
+		t.Fatal("did not fire at its deadline")
+	}
+	if got := f.Now(); !got.Equal(epoch.Add(time.Minute)) {
+		t.Errorf("Now = %v", got)
+	}
+	if !fired(f.After(0)) {
+		t.Error("After(0) should fire immediately")
+	}
+}
+
+func TestFake_Ticker(t *testing.T) {
+	f := NewFake(epoch)
+	tk := f.NewTicker(10 * time.Second)
+
+	f.Advance(10 * time.Second)
+	if !fired(tk.C()) {
+		t.Fatal("first tick missing")
+	}
+	// Several periods at once deliver a single tick, like time.Ticker.
+	f.Advance(30 * time.Second)
+	if !fired(tk.C()) || fired(tk.C()) {
+		t.Fatal("want exactly one buffered tick")
+	}
+	tk.Stop()
+	f.Advance(time.Minute)
+	if fired(tk.C()) {
+		t.Error("stopped ticker fired")
+	}
+}
+
+func TestFake_SleepAndBlockUntil(t *testing.T) {
+	f := NewFake(epoch)
+	done := make(chan struct{})
+	go func() {
+		f.Sleep(time.Hour)
+		close(done)
+	}()
+
+	f.BlockUntil(1)
+	f.Advance(time.Hour)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sleep did not return after Advance")
+	}
+}
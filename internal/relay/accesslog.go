@@ -0,0 +1,115 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EnableAccessLog logs one structured line per HTTP request. Call it before
+// Handler. It is off by default so tests and dev runs stay quiet.
+func (s *Server) EnableAccessLog() {
+	s.accessLog = true
+}
+
+type accessRecordKey struct{}
+
+// accessRecord collects details that only handlers learn (who the caller
+// is, which machine a bridge targets) for the access log line. Bridges run
+// on their own goroutines long after the handler returns its first byte,
+// hence the mutex.
+type accessRecord struct {
+	mu      sync.Mutex
+	sub     string
+	machine string
+}
+
+// noteIdentity records the authenticated caller for the access log. It is a
+// no-op when access logging is off.
+func noteIdentity(ctx context.Context, provider, sub string) {
+	if rec, ok := ctx.Value(accessRecordKey{}).(*accessRecord); ok {
+		rec.mu.Lock()
+		rec.sub = provider + ":" + sub
+		rec.mu.Unlock()
+	}
+}
+
+// noteMachine records the machine a bridge request targets.
+func noteMachine(ctx context.Context, machineID string) {
+	if rec, ok := ctx.Value(accessRecordKey{}).(*accessRecord); ok {
+		rec.mu.Lock()
+		rec.machine = machineID
+		rec.mu.Unlock()
+	}
+}
+
+func (s *Server) accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecord{}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessRecordKey{}, rec)))
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote", r.RemoteAddr),
+		}
+		rec.mu.Lock()
+		if rec.sub != "" {
+			attrs = append(attrs, slog.String("sub", rec.sub))
+		}
+		if rec.machine != "" {
+			attrs = append(attrs, slog.String("machine", rec.machine))
+		}
+		rec.mu.Unlock()
+		if status == http.StatusSwitchingProtocols {
+			attrs = append(attrs, slog.String("subprotocol", sw.Header().Get("Sec-WebSocket-Protocol")))
+		}
+		s.logger.LogAttrs(r.Context(), slog.LevelInfo, "http request", attrs...)
+	})
+}
+
+// statusWriter captures the response status. It passes Hijack through so
+// WebSocket upgrades still work behind it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return hj.Hijack()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
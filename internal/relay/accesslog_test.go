@@ -0,0 +1,145 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	dbstore "github.com/brporter/phosphor/internal/store"
+)
+
+// decodeLogLines parses every JSON log line in buf whose msg is "http request".
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]any
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("bad log line %q: %v", line, err)
+		}
+		if m["msg"] == "http request" {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func TestAccessLog_Health(t *testing.T) {
+	var buf bytes.Buffer
+	authSessions := NewMemoryAuthSessionStore(5 * time.Minute)
+	t.Cleanup(authSessions.Stop)
+	s := NewServer(slog.New(slog.NewJSONHandler(&buf, nil)), "http://test", nil, false, authSessions, nil, dbstore.NewFake())
+	s.EnableAccessLog()
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 1 {
+		t.Fatalf("got %d access log lines, want 1: %s", len(lines), buf.String())
+	}
+	l := lines[0]
+	if l["method"] != "GET" || l["path"] != "/health" || l["status"] != float64(200) || l["remote"] != "192.0.2.1:1234" {
+		t.Errorf("unexpected fields: %v", l)
+	}
+	if _, ok := l["duration"]; !ok {
+		t.Error("missing duration")
+	}
+	if _, ok := l["sub"]; ok {
+		t.Error("unauthenticated request logged a sub")
+	}
+}
+
+func TestAccessLog_OffByDefault(t *testing.T) {
+	var buf bytes.Buffer
+	authSessions := NewMemoryAuthSessionStore(5 * time.Minute)
+	t.Cleanup(authSessions.Stop)
+	s := NewServer(slog.New(slog.NewJSONHandler(&buf, nil)), "http://test", nil, false, authSessions, nil, dbstore.NewFake())
+
+	s.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if lines := decodeLogLines(t, &buf); len(lines) != 0 {
+		t.Errorf("got %d access log lines with logging off", len(lines))
+	}
+}
+
+func TestAccessLog_AuthenticatedSub(t *testing.T) {
+	var buf bytes.Buffer
+	s, _ := newMachinesTestServer(t)
+	s.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	s.EnableAccessLog()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/machines", nil)
+	req.Header.Set("Authorization", "Bearer google:alice")
+	s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 1 || lines[0]["sub"] != "google:alice" {
+		t.Fatalf("access log = %v", lines)
+	}
+}
+
+func TestAccessLog_WebSocketUpgrade(t *testing.T) {
+	var buf syncBuffer
+	ts, machineID := newBridgeServerWith(t, true, func(s *Server) {
+		s.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+		s.EnableAccessLog()
+	})
+	conn := dialBridge(t, ts, machineID)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	if _, _, err := conn.Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close(websocket.StatusNormalClosure, "")
+
+	// The line is written when the bridge handler returns.
+	deadline := time.Now().Add(3 * time.Second)
+	for !strings.Contains(buf.String(), `"http request"`) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no access log line: %s", buf.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lines := decodeLogLines(t, bytes.NewBufferString(buf.String()))
+	l := lines[0]
+	if l["status"] != float64(101) || l["subprotocol"] != bridgeSubprotocol || l["machine"] != machineID || l["sub"] != "google:alice" {
+		t.Errorf("unexpected fields: %v", l)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes a bridge's
+// goroutines make to its logger.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -9,6 +9,20 @@ import (
 	"github.com/brporter/phosphor/internal/auth"
 )
 
+// devIdentityHeader lets dev-mode clients pick an identity per request
+// without crafting tokens, e.g. to act as several users against one local
+// relay. It is ignored outside dev mode.
+const devIdentityHeader = "X-Phosphor-Dev-Identity"
+
+// parseDevIdentity splits a dev-mode "provider:sub" identity.
+func parseDevIdentity(s string) (provider, sub string, ok bool) {
+	provider, sub, ok = strings.Cut(s, ":")
+	if !ok || provider == "" || sub == "" {
+		return "", "", false
+	}
+	return provider, sub, true
+}
+
 // verifyToken validates an auth token and returns (provider, sub, email, err).
 func (s *Server) verifyToken(ctx context.Context, token string) (string, string, string, error) {
 	if token == "" && s.devMode {
@@ -36,14 +50,25 @@ func (s *Server) verifyToken(ctx context.Context, token string) (string, string,
 		if err != nil {
 			return "", "", "", err
 		}
-		return provider, sub, "", nil
+		// A key outlives the sign-in that minted it, so the allow-list is
+		// rechecked against the owner's email from their latest sign-in.
+		if s.verifier == nil || !s.verifier.RestrictsDomains() {
+			return provider, sub, "", nil
+		}
+		user, err := s.db.GetOrCreateUser(ctx, provider, sub, "")
+		if err != nil {
+			return "", "", "", fmt.Errorf("looking up api key owner: %w", err)
+		}
+		if err := s.verifier.CheckRecordedEmail(user.Email); err != nil {
+			return "", "", "", err
+		}
+		return provider, sub, user.Email, nil
 	}
 
 	// Dev-mode fallback: parse token as "provider:sub"
 	if s.devMode {
-		parts := strings.SplitN(token, ":", 2)
-		if len(parts) == 2 {
-			return parts[0], parts[1], "", nil
+		if provider, sub, ok := parseDevIdentity(token); ok {
+			return provider, sub, "", nil
 		}
 	}
 
@@ -66,6 +91,17 @@ func (s *Server) verifyToken(ctx context.Context, token string) (string, string,
 	return "", "", "", auth.ErrNoToken
 }
 
+// identify resolves the caller of r, who presented token. In dev mode the
+// dev identity header, when present, wins over the token.
+func (s *Server) identify(ctx context.Context, r *http.Request, token string) (string, string, string, error) {
+	if s.devMode {
+		if provider, sub, ok := parseDevIdentity(r.Header.Get(devIdentityHeader)); ok {
+			return provider, sub, "", nil
+		}
+	}
+	return s.verifyToken(ctx, token)
+}
+
 // extractIdentity extracts the user identity from the request.
 func (s *Server) extractIdentity(r *http.Request) (string, string, string, error) {
 	hdr := r.Header.Get("Authorization")
@@ -73,5 +109,9 @@ func (s *Server) extractIdentity(r *http.Request) (string, string, string, error
 	if token == hdr {
 		token = "" // no "Bearer " prefix found
 	}
-	return s.verifyToken(r.Context(), token)
+	provider, sub, email, err := s.identify(r.Context(), r, token)
+	if err == nil {
+		noteIdentity(r.Context(), provider, sub)
+	}
+	return provider, sub, email, err
 }
@@ -131,6 +131,45 @@ func TestVerifyToken_APIKey_Valid(t *testing.T) {
 	}
 }
 
+func TestVerifyToken_APIKey_DomainAllowList(t *testing.T) {
+	secret := []byte("test-secret-32-bytes-long-enough")
+	db := store.NewFake()
+	mint := func(sub, email string) string {
+		t.Helper()
+		rawJWT, keyID, err := GenerateAPIKey(secret, "microsoft", sub)
+		if err != nil {
+			t.Fatalf("GenerateAPIKey: %v", err)
+		}
+		user, err := db.GetOrCreateUser(t.Context(), "microsoft", sub, email)
+		if err != nil {
+			t.Fatalf("GetOrCreateUser: %v", err)
+		}
+		if err := db.RecordAPIKey(t.Context(), keyID, user.ID); err != nil {
+			t.Fatalf("RecordAPIKey: %v", err)
+		}
+		return "phk:" + rawJWT
+	}
+	insider := mint("alice", "alice@company.com")
+	outsider := mint("mallory", "mallory@evil.com")
+
+	verifier := auth.NewVerifier(slog.Default())
+	s := &Server{logger: slog.Default(), apiKeySecret: secret, db: db, verifier: verifier}
+
+	// Keys minted before the allow-list was turned on are rechecked once
+	// it is.
+	if _, _, _, err := s.verifyToken(t.Context(), outsider); err != nil {
+		t.Fatalf("outsider key without an allow-list: %v", err)
+	}
+	verifier.SetAllowedDomains([]string{"company.com"})
+
+	if _, _, email, err := s.verifyToken(t.Context(), insider); err != nil || email != "alice@company.com" {
+		t.Errorf("insider key: email=%q err=%v", email, err)
+	}
+	if _, _, _, err := s.verifyToken(t.Context(), outsider); !errors.Is(err, auth.ErrDomainNotAllowed) {
+		t.Errorf("outsider key err = %v, want ErrDomainNotAllowed", err)
+	}
+}
+
 func TestVerifyToken_APIKey_Revoked(t *testing.T) {
 	secret := []byte("test-secret-32-bytes-long-enough")
 	rawJWT, keyID, err := GenerateAPIKey(secret, "microsoft", "user123")
@@ -228,3 +267,41 @@ func TestVerifyToken_APIKey_WrongSecret(t *testing.T) {
 		t.Fatal("expected error for wrong secret, got nil")
 	}
 }
+
+func TestParseDevIdentity(t *testing.T) {
+	tests := []struct {
+		in            string
+		provider, sub string
+		ok            bool
+	}{
+		{"google:alice", "google", "alice", true},
+		{"oidc:a:b", "oidc", "a:b", true},
+		{"noseparator", "", "", false},
+		{":alice", "", "", false},
+		{"google:", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tc := range tests {
+		provider, sub, ok := parseDevIdentity(tc.in)
+		if provider != tc.provider || sub != tc.sub || ok != tc.ok {
+			t.Errorf("parseDevIdentity(%q) = %q, %q, %v", tc.in, provider, sub, ok)
+		}
+	}
+}
+
+func TestExtractIdentity_DevIdentityHeader(t *testing.T) {
+	for _, devMode := range []bool{true, false} {
+		s := &Server{devMode: devMode, logger: slog.Default(), db: store.NewFake()}
+		r, _ := http.NewRequest(http.MethodGet, "/api/machines", nil)
+		r.Header.Set("Authorization", "Bearer google:alice")
+		r.Header.Set(devIdentityHeader, "google:bob")
+
+		provider, sub, _, err := s.extractIdentity(r)
+		switch {
+		case devMode && (err != nil || provider != "google" || sub != "bob"):
+			t.Errorf("dev mode: identity = %q:%q, %v; want the header's google:bob", provider, sub, err)
+		case !devMode && err == nil:
+			t.Errorf("non-dev mode: header accepted as %q:%q", provider, sub)
+		}
+	}
+}
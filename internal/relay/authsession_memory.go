@@ -2,10 +2,23 @@ package relay
 
 import (
 	"context"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	nanoid "github.com/matoous/go-nanoid/v2"
+
+	"github.com/brporter/phosphor/internal/clock"
+)
+
+const (
+	// defaultAuthSessionTTL is how long a pending login lives when no TTL
+	// is given.
+	defaultAuthSessionTTL = 5 * time.Minute
+	// defaultAuthSessionSweep is how often expired sessions are purged when
+	// no interval is given.
+	defaultAuthSessionSweep = 30 * time.Second
 )
 
 // MemoryAuthSessionStore is an in-memory implementation of AuthSessionStoreI.
@@ -13,17 +26,40 @@ type MemoryAuthSessionStore struct {
 	mu       sync.Mutex
 	sessions map[string]AuthSessionData
 	ttl      time.Duration
+	clock    clock.Clock
 	stopCh   chan struct{}
+	logger   *slog.Logger
+	swept    atomic.Int64
 }
 
 // NewMemoryAuthSessionStore creates a store with background cleanup.
 func NewMemoryAuthSessionStore(ttl time.Duration) *MemoryAuthSessionStore {
+	return NewMemoryAuthSessionStoreWithSweep(ttl, defaultAuthSessionSweep, nil)
+}
+
+// NewMemoryAuthSessionStoreWithSweep creates a store that purges expired
+// sessions every interval. Non-positive ttl or interval select the defaults.
+// Sweeps that remove anything are logged at debug level when logger is
+// non-nil.
+func NewMemoryAuthSessionStoreWithSweep(ttl, interval time.Duration, logger *slog.Logger) *MemoryAuthSessionStore {
+	return newMemoryAuthSessionStore(ttl, interval, logger, clock.Real)
+}
+
+func newMemoryAuthSessionStore(ttl, interval time.Duration, logger *slog.Logger, clk clock.Clock) *MemoryAuthSessionStore {
+	if ttl <= 0 {
+		ttl = defaultAuthSessionTTL
+	}
+	if interval <= 0 {
+		interval = defaultAuthSessionSweep
+	}
 	s := &MemoryAuthSessionStore{
 		sessions: make(map[string]AuthSessionData),
 		ttl:      ttl,
+		clock:    clk,
 		stopCh:   make(chan struct{}),
+		logger:   logger,
 	}
-	go s.cleanup()
+	go s.cleanup(interval)
 	return s
 }
 
@@ -34,7 +70,7 @@ func (s *MemoryAuthSessionStore) Create(_ context.Context, provider, codeVerifie
 		Provider:     provider,
 		CodeVerifier: codeVerifier,
 		Source:       source,
-		CreatedAt:    time.Now(),
+		CreatedAt:    s.clock.Now(),
 	}
 	s.mu.Lock()
 	s.sessions[id] = sess
@@ -49,7 +85,7 @@ func (s *MemoryAuthSessionStore) Get(_ context.Context, id string) (AuthSessionD
 	if !ok {
 		return AuthSessionData{}, false, nil
 	}
-	if time.Since(sess.CreatedAt) > s.ttl {
+	if s.clock.Now().Sub(sess.CreatedAt) > s.ttl {
 		delete(s.sessions, id)
 		return AuthSessionData{}, false, nil
 	}
@@ -89,26 +125,45 @@ func (s *MemoryAuthSessionStore) Consume(_ context.Context, id string) (string,
 	return token, true, nil
 }
 
+func (s *MemoryAuthSessionStore) TTL() time.Duration {
+	return s.ttl
+}
+
 func (s *MemoryAuthSessionStore) Stop() {
 	close(s.stopCh)
 }
 
-func (s *MemoryAuthSessionStore) cleanup() {
-	ticker := time.NewTicker(30 * time.Second)
+// Swept reports how many expired sessions the background sweep has removed.
+func (s *MemoryAuthSessionStore) Swept() int64 {
+	return s.swept.Load()
+}
+
+func (s *MemoryAuthSessionStore) cleanup(interval time.Duration) {
+	ticker := s.clock.NewTicker(interval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-s.stopCh:
 			return
-		case <-ticker.C:
-			s.mu.Lock()
-			now := time.Now()
-			for id, sess := range s.sessions {
-				if now.Sub(sess.CreatedAt) > s.ttl {
-					delete(s.sessions, id)
-				}
+		case <-ticker.C():
+			if n := s.sweep(s.clock.Now()); n > 0 && s.logger != nil {
+				s.logger.Debug("swept expired auth sessions", "removed", n)
 			}
-			s.mu.Unlock()
 		}
 	}
 }
+
+// sweep deletes sessions older than the TTL and returns how many it removed.
+func (s *MemoryAuthSessionStore) sweep(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, sess := range s.sessions {
+		if now.Sub(sess.CreatedAt) > s.ttl {
+			delete(s.sessions, id)
+			n++
+		}
+	}
+	s.swept.Add(int64(n))
+	return n
+}
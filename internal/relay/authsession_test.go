@@ -4,6 +4,8 @@ import (
 	"context"
 	"testing"
 	"time"
+
+	"github.com/brporter/phosphor/internal/clock"
 )
 
 func TestAuthSessionStore_CreateAndGet(t *testing.T) {
@@ -90,7 +92,8 @@ func TestAuthSessionStore_Consume(t *testing.T) {
 }
 
 func TestAuthSessionStore_Expiry(t *testing.T) {
-	store := NewMemoryAuthSessionStore(50 * time.Millisecond)
+	clk := clock.NewFake(time.Unix(0, 0))
+	store := newMemoryAuthSessionStore(5*time.Minute, 0, nil, clk)
 	defer store.Stop()
 	ctx := context.Background()
 
@@ -98,10 +101,58 @@ func TestAuthSessionStore_Expiry(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Create error: %v", err)
 	}
-	time.Sleep(100 * time.Millisecond)
 
-	_, ok, _ := store.Get(ctx, sess.ID)
-	if ok {
+	clk.Advance(5 * time.Minute)
+	if _, ok, _ := store.Get(ctx, sess.ID); !ok {
+		t.Fatal("session expired at, not after, its TTL")
+	}
+	clk.Advance(time.Second)
+	if _, ok, _ := store.Get(ctx, sess.ID); ok {
 		t.Error("session should have expired")
 	}
 }
+
+func TestAuthSessionStore_DefaultTTL(t *testing.T) {
+	store := NewMemoryAuthSessionStoreWithSweep(0, 0, nil)
+	defer store.Stop()
+	if store.TTL() != defaultAuthSessionTTL {
+		t.Errorf("TTL = %v, want %v", store.TTL(), defaultAuthSessionTTL)
+	}
+}
+
+func TestAuthSessionStore_BackgroundSweep(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	store := newMemoryAuthSessionStore(time.Minute, 30*time.Second, nil, clk)
+	defer store.Stop()
+	ctx := context.Background()
+
+	for range 3 {
+		if _, err := store.Create(ctx, "google", "verifier", "cli"); err != nil {
+			t.Fatalf("Create error: %v", err)
+		}
+	}
+
+	// Nothing has expired at the first sweep; once the clock is past the
+	// TTL, the next sweep removes all three.
+	clk.BlockUntil(1)
+	clk.Advance(30 * time.Second)
+	if got := store.Swept(); got != 0 {
+		t.Fatalf("Swept() = %d before the TTL, want 0", got)
+	}
+	clk.Advance(60 * time.Second)
+
+	// The sweep itself runs on the cleanup goroutine.
+	deadline := time.Now().Add(2 * time.Second)
+	for store.Swept() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := store.Swept(); got != 3 {
+		t.Fatalf("Swept() = %d, want 3", got)
+	}
+	store.mu.Lock()
+	remaining := len(store.sessions)
+	store.mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("%d sessions left after sweep, want 0", remaining)
+	}
+}
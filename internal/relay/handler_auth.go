@@ -30,6 +30,14 @@ func codeChallenge(verifier string) string {
 	return base64.RawURLEncoding.EncodeToString(h[:])
 }
 
+// authNonce derives the OIDC nonce for a login from its (server-side,
+// secret) PKCE verifier, so it binds an id_token to this auth session
+// without storing anything extra.
+func authNonce(verifier string) string {
+	h := sha256.Sum256([]byte("nonce:" + verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
 // --- Request/Response types ---
 
 type authLoginRequest struct {
@@ -42,7 +50,8 @@ type authLoginResponse struct {
 	AuthURL   string `json:"auth_url"`
 }
 
-// HandleAuthConfig returns the list of available authentication providers.
+// HandleAuthConfig returns the list of available authentication providers and
+// whether the relay is running in dev mode, so clients can warn the user.
 // GET /api/auth/config
 func (s *Server) HandleAuthConfig(w http.ResponseWriter, r *http.Request) {
 	providers := s.verifier.ProviderNames()
@@ -50,7 +59,7 @@ func (s *Server) HandleAuthConfig(w http.ResponseWriter, r *http.Request) {
 		providers = append([]string{"dev"}, providers...)
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{"providers": providers})
+	json.NewEncoder(w).Encode(map[string]any{"providers": providers, "dev_mode": s.devMode})
 }
 
 // generateDevToken creates a synthetic unsigned JWT for dev-mode authentication.
@@ -152,15 +161,23 @@ func (s *Server) HandleAuthAuthorize(w http.ResponseWriter, r *http.Request) {
 		"client_id":             {cfg.ClientID},
 		"redirect_uri":          {redirectURI},
 		"response_type":         {"code"},
-		"scope":                 {"openid email profile"},
+		"scope":                 {strings.Join(cfg.RequestScopes(), " ")},
 		"state":                 {sessionID},
 		"code_challenge":        {codeChallenge(sess.CodeVerifier)},
 		"code_challenge_method": {"S256"},
 	}
 
-	// Apple-specific: use response_mode=form_post
+	if cfg.Audience != "" {
+		params.Set("audience", cfg.Audience)
+	}
+
+	// Apple-specific: use response_mode=form_post, and have Apple post an
+	// id_token bound to our nonce alongside the code so the callback can
+	// verify the POST really came from this login before exchanging.
 	if sess.Provider == "apple" {
 		params.Set("response_mode", "form_post")
+		params.Set("response_type", "code id_token")
+		params.Set("nonce", authNonce(sess.CodeVerifier))
 	}
 
 	// Microsoft-specific: always show account picker
@@ -175,7 +192,7 @@ func (s *Server) HandleAuthAuthorize(w http.ResponseWriter, r *http.Request) {
 // HandleAuthCallback handles the OIDC provider's redirect back.
 // GET or POST /api/auth/callback  (Apple uses POST with form_post)
 func (s *Server) HandleAuthCallback(w http.ResponseWriter, r *http.Request) {
-	var code, state string
+	var code, state, postedIDToken string
 
 	if r.Method == http.MethodPost {
 		if err := r.ParseForm(); err != nil {
@@ -184,6 +201,7 @@ func (s *Server) HandleAuthCallback(w http.ResponseWriter, r *http.Request) {
 		}
 		code = r.FormValue("code")
 		state = r.FormValue("state")
+		postedIDToken = r.PostFormValue("id_token")
 	} else {
 		code = r.URL.Query().Get("code")
 		state = r.URL.Query().Get("state")
@@ -218,6 +236,21 @@ func (s *Server) HandleAuthCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Apple's form_post is the easiest callback to forge: anyone can POST a
+	// code and a guessed state. Require the id_token Apple posts with it to
+	// be genuinely signed for us and bound to this session's nonce.
+	if sess.Provider == "apple" {
+		if r.Method != http.MethodPost || postedIDToken == "" {
+			s.renderAuthResult(w, false, "invalid Apple callback")
+			return
+		}
+		if _, err := s.verifier.VerifyProviderToken(ctx, "apple", postedIDToken, authNonce(sess.CodeVerifier)); err != nil {
+			s.logger.Warn("rejected Apple callback", slog.String("err", err.Error()))
+			s.renderAuthResult(w, false, "invalid Apple callback")
+			return
+		}
+	}
+
 	cfg, _ := s.verifier.GetProvider(sess.Provider)
 	tokenEndpoint, ok := s.verifier.GetTokenEndpoint(sess.Provider)
 	if !ok {
@@ -247,7 +280,15 @@ func (s *Server) HandleAuthCallback(w http.ResponseWriter, r *http.Request) {
 		data.Set("client_secret", cfg.ClientSecret)
 	}
 
-	tokenResp, err := http.Post(tokenEndpoint, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+	tokenReq, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		s.renderAuthResult(w, false, "internal error")
+		return
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// GitHub answers form-encoded unless JSON is asked for explicitly.
+	tokenReq.Header.Set("Accept", "application/json")
+	tokenResp, err := http.DefaultClient.Do(tokenReq)
 	if err != nil {
 		s.logger.Error("token exchange request", slog.String("err", err.Error()))
 		s.renderAuthResult(w, false, "token exchange failed")
@@ -257,8 +298,9 @@ func (s *Server) HandleAuthCallback(w http.ResponseWriter, r *http.Request) {
 
 	body, _ := io.ReadAll(tokenResp.Body)
 	var tokenResult struct {
-		IDToken string `json:"id_token"`
-		Error   string `json:"error"`
+		IDToken     string `json:"id_token"`
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
 	}
 	if err := json.Unmarshal(body, &tokenResult); err != nil {
 		s.renderAuthResult(w, false, "invalid token response")
@@ -270,12 +312,18 @@ func (s *Server) HandleAuthCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if tokenResult.IDToken == "" {
+	// Providers without ID tokens (GitHub) hand out an opaque access token
+	// instead; the verifier resolves it through the provider's userinfo API.
+	token := tokenResult.IDToken
+	if cfg.UserInfoURL != "" {
+		token = tokenResult.AccessToken
+	}
+	if token == "" {
 		s.renderAuthResult(w, false, "no id_token in response")
 		return
 	}
 
-	s.authSessions.Complete(ctx, state, tokenResult.IDToken)
+	s.authSessions.Complete(ctx, state, token)
 
 	// Web-originated logins redirect back to the SPA; mobile/desktop logins redirect
 	// to the phosphor:// custom scheme; CLI logins show a success page.
@@ -309,6 +357,8 @@ func (s *Server) HandleAuthPoll(w http.ResponseWriter, r *http.Request) {
 }
 
 // HandleCLIStart creates an auth session with no provider selected yet.
+// expires_in tells the CLI how long to keep polling before the relay
+// forgets the session.
 // POST /api/auth/cli-start
 func (s *Server) HandleCLIStart(w http.ResponseWriter, r *http.Request) {
 	sess, err := s.authSessions.Create(r.Context(), "", "", "cli")
@@ -317,7 +367,10 @@ func (s *Server) HandleCLIStart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"session_id": sess.ID})
+	json.NewEncoder(w).Encode(map[string]any{
+		"session_id": sess.ID,
+		"expires_in": int(s.authSessions.TTL().Seconds()),
+	})
 }
 
 // HandleCLILogin serves a minimal HTML provider-picker page.
@@ -412,7 +465,7 @@ func (s *Server) HandleCLIChoose(w http.ResponseWriter, r *http.Request) {
 func (s *Server) HandleGenerateAPIKey(w http.ResponseWriter, r *http.Request) {
 	provider, sub, email, err := s.extractIdentity(r)
 	if err != nil {
-		http.Error(w, `{"error":"authentication required"}`, http.StatusUnauthorized)
+		writeAuthError(w, err)
 		return
 	}
 
@@ -449,10 +502,16 @@ func (s *Server) HandleGenerateAPIKey(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// authCompleteScript notifies an opener window (same origin only, via the
+// "/" target) that login finished, then tries to close the tab. Browsers
+// refuse window.close() for tabs the user opened, in which case the page
+// text still tells them to close it. It embeds no request-derived values.
+const authCompleteScript = `<script>(function(){try{if(window.opener){window.opener.postMessage({type:"phosphor-auth",status:"complete"},"/")}}catch(e){}setTimeout(function(){window.close()},1500)})();</script>`
+
 func (s *Server) renderAuthResult(w http.ResponseWriter, success bool, errMsg string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if success {
-		fmt.Fprintf(w, `<!DOCTYPE html><html><body style="background:#0a0a0a;color:#00ff41;font-family:monospace;display:flex;justify-content:center;align-items:center;height:100vh;margin:0"><div style="text-align:center"><h2>Authentication Complete</h2><p>You can close this tab and return to your terminal.</p><p style="margin-top:1em"><a href="%s" style="color:#00ff41">View your sessions</a></p></div></body></html>`, html.EscapeString(s.baseURL))
+		fmt.Fprintf(w, `<!DOCTYPE html><html><body style="background:#0a0a0a;color:#00ff41;font-family:monospace;display:flex;justify-content:center;align-items:center;height:100vh;margin:0"><div style="text-align:center"><h2>Authentication Complete</h2><p>You can close this tab and return to your terminal.</p><p style="margin-top:1em"><a href="%s" style="color:#00ff41">View your sessions</a></p></div>%s</body></html>`, html.EscapeString(s.baseURL), authCompleteScript)
 	} else {
 		safeMsg := html.EscapeString(errMsg)
 		fmt.Fprintf(w, `<!DOCTYPE html><html><body style="background:#0a0a0a;color:#ff4444;font-family:monospace;display:flex;justify-content:center;align-items:center;height:100vh;margin:0"><div style="text-align:center"><h2>Authentication Failed</h2><p>%s</p></div></body></html>`, safeMsg)
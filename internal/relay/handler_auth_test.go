@@ -2,6 +2,9 @@ package relay
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/json"
 	"io"
 	"log/slog"
@@ -12,6 +15,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+
 	"github.com/brporter/phosphor/internal/auth"
 
 	dbstore "github.com/brporter/phosphor/internal/store"
@@ -21,6 +27,13 @@ import (
 // The mock OIDC httptest.Server serves discovery, JWKS, and token endpoints.
 func newTestAuthServer(t *testing.T) *Server {
 	t.Helper()
+	return newTestAuthServerWith(t, func(*auth.ProviderConfig) {})
+}
+
+// newTestAuthServerWith is newTestAuthServer with a hook to adjust the mock
+// provider's config before it is registered.
+func newTestAuthServerWith(t *testing.T, configure func(*auth.ProviderConfig)) *Server {
+	t.Helper()
 
 	mux := http.NewServeMux()
 	oidcServer := httptest.NewServer(mux)
@@ -47,12 +60,14 @@ func newTestAuthServer(t *testing.T) *Server {
 		json.NewEncoder(w).Encode(map[string]string{"id_token": "mock-id-token-value"})
 	})
 
-	verifier := auth.NewVerifier(slog.Default())
-	err := verifier.AddProvider(context.Background(), auth.ProviderConfig{
+	cfg := auth.ProviderConfig{
 		Name:     "test",
 		Issuer:   oidcServer.URL,
 		ClientID: "test-client-id",
-	})
+	}
+	configure(&cfg)
+	verifier := auth.NewVerifier(slog.Default())
+	err := verifier.AddProvider(context.Background(), cfg)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -116,10 +131,14 @@ func TestHandleAuthConfig_DevMode(t *testing.T) {
 
 	var result struct {
 		Providers []string `json:"providers"`
+		DevMode   bool     `json:"dev_mode"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		t.Fatalf("decode: %v", err)
 	}
+	if !result.DevMode {
+		t.Error("dev_mode = false, want true")
+	}
 
 	// "dev" should be first, then the registered "test" provider.
 	if len(result.Providers) < 2 {
@@ -141,9 +160,13 @@ func TestHandleAuthConfig_NoDevMode(t *testing.T) {
 
 	var result struct {
 		Providers []string `json:"providers"`
+		DevMode   bool     `json:"dev_mode"`
 	}
 	json.NewDecoder(w.Result().Body).Decode(&result)
 
+	if result.DevMode {
+		t.Error("dev_mode = true, want false")
+	}
 	for _, p := range result.Providers {
 		if p == "dev" {
 			t.Error("dev provider should not appear when devMode is false")
@@ -331,6 +354,40 @@ func TestHandleAuthAuthorize_Success(t *testing.T) {
 	if !strings.Contains(q.Get("redirect_uri"), "/api/auth/callback") {
 		t.Errorf("redirect_uri %q does not contain /api/auth/callback", q.Get("redirect_uri"))
 	}
+
+	if q.Get("scope") != "openid email profile" {
+		t.Errorf("scope = %q, want the default %q", q.Get("scope"), "openid email profile")
+	}
+	if q.Has("audience") {
+		t.Errorf("audience = %q, want it omitted when unconfigured", q.Get("audience"))
+	}
+}
+
+func TestHandleAuthAuthorize_ConfiguredScopes(t *testing.T) {
+	s := newTestAuthServerWith(t, func(cfg *auth.ProviderConfig) {
+		cfg.Scopes = []string{"openid", "email", "offline_access"}
+		cfg.Audience = "api://phosphor"
+	})
+	sess, err := s.authSessions.Create(context.Background(), "test", "test-code-verifier", "cli")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/authorize?session="+sess.ID, nil)
+	w := httptest.NewRecorder()
+	s.HandleAuthAuthorize(w, r)
+
+	location, err := url.Parse(w.Result().Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse Location: %v", err)
+	}
+	q := location.Query()
+	if q.Get("scope") != "openid email offline_access" {
+		t.Errorf("scope = %q, want %q", q.Get("scope"), "openid email offline_access")
+	}
+	if q.Get("audience") != "api://phosphor" {
+		t.Errorf("audience = %q, want %q", q.Get("audience"), "api://phosphor")
+	}
 }
 
 func TestHandleAuthAuthorize_InvalidSession(t *testing.T) {
@@ -512,6 +569,28 @@ func TestHandleAuthPoll_Complete(t *testing.T) {
 	}
 }
 
+// --- HandleCLIStart ---
+
+func TestHandleCLIStart_ReportsExpiry(t *testing.T) {
+	authSessions := NewMemoryAuthSessionStore(90 * time.Second)
+	t.Cleanup(authSessions.Stop)
+	s := NewServer(slog.Default(), "http://test", auth.NewVerifier(slog.Default()), true, authSessions, nil, dbstore.NewFake())
+
+	w := httptest.NewRecorder()
+	s.HandleCLIStart(w, httptest.NewRequest(http.MethodPost, "/api/auth/cli-start", nil))
+
+	var result struct {
+		SessionID string `json:"session_id"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if result.SessionID == "" || result.ExpiresIn != 90 {
+		t.Errorf("cli-start = %+v, want a session expiring in 90s", result)
+	}
+}
+
 // --- HandleGenerateAPIKey ---
 
 func TestHandleGenerateAPIKey_Success(t *testing.T) {
@@ -586,3 +665,291 @@ func TestRenderAuthResult_XSS(t *testing.T) {
 		t.Errorf("body %q does not contain escaped &lt;script&gt;", body)
 	}
 }
+
+func TestRenderAuthResult_SuccessClosesTab(t *testing.T) {
+	s := newTestAuthServer(t)
+	w := httptest.NewRecorder()
+
+	s.renderAuthResult(w, true, "")
+
+	body := w.Body.String()
+	if !strings.Contains(body, "window.opener.postMessage(") {
+		t.Error("success page does not post the result to its opener")
+	}
+	if !strings.Contains(body, `"/")`) {
+		t.Error("postMessage must target the page's own origin, not a wildcard")
+	}
+	if !strings.Contains(body, "window.close()") {
+		t.Error("success page does not attempt to close the tab")
+	}
+}
+
+func TestRenderAuthResult_ErrorHasNoScript(t *testing.T) {
+	s := newTestAuthServer(t)
+	w := httptest.NewRecorder()
+
+	s.renderAuthResult(w, false, `"><img src=x onerror=alert(1)>`)
+
+	body := w.Body.String()
+	if strings.Contains(body, "<script") || strings.Contains(body, "<img") {
+		t.Errorf("error page contains active content: %q", body)
+	}
+}
+
+// --- Apple form_post hardening ---
+
+// newTestAppleAuthServer registers an "apple" provider backed by a mock
+// issuer whose JWKS holds a real key, and returns a function that signs
+// id_tokens with it.
+func newTestAppleAuthServer(t *testing.T) (*Server, func(nonce string) string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := jose.JSONWebKey{Key: &key.PublicKey, KeyID: "k1", Algorithm: string(jose.ES256), Use: "sig"}
+
+	mux := http.NewServeMux()
+	oidcServer := httptest.NewServer(mux)
+	t.Cleanup(oidcServer.Close)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                oidcServer.URL,
+			"authorization_endpoint":                oidcServer.URL + "/authorize",
+			"token_endpoint":                        oidcServer.URL + "/token",
+			"jwks_uri":                              oidcServer.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"ES256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"id_token": "exchanged-id-token"})
+	})
+
+	verifier := auth.NewVerifier(slog.Default())
+	if err := verifier.AddProvider(context.Background(), auth.ProviderConfig{
+		Name:     "apple",
+		Issuer:   oidcServer.URL,
+		ClientID: "com.example.phosphor",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	authSessions := NewMemoryAuthSessionStore(5 * time.Minute)
+	t.Cleanup(authSessions.Stop)
+	s := NewServer(slog.Default(), "http://localhost:8080", verifier, true, authSessions, nil, dbstore.NewFake())
+
+	sign := func(nonce string) string {
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key},
+			(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "k1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		raw, err := jwt.Signed(signer).Claims(map[string]any{
+			"iss":   oidcServer.URL,
+			"aud":   "com.example.phosphor",
+			"sub":   "apple-user",
+			"iat":   now.Unix(),
+			"exp":   now.Add(5 * time.Minute).Unix(),
+			"nonce": nonce,
+		}).Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	return s, sign
+}
+
+func postAppleCallback(t *testing.T, s *Server, form url.Values) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/callback", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.HandleAuthCallback(w, r)
+	body, _ := io.ReadAll(w.Result().Body)
+	return string(body)
+}
+
+func TestHandleAuthAuthorize_AppleRequestsNonce(t *testing.T) {
+	s, _ := newTestAppleAuthServer(t)
+	sess, err := s.authSessions.Create(context.Background(), "apple", "apple-verifier", "cli")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/authorize?session="+sess.ID, nil)
+	w := httptest.NewRecorder()
+	s.HandleAuthAuthorize(w, r)
+
+	location, err := url.Parse(w.Result().Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse Location: %v", err)
+	}
+	q := location.Query()
+	if q.Get("response_mode") != "form_post" || q.Get("response_type") != "code id_token" {
+		t.Errorf("response_mode=%q response_type=%q, want form_post / \"code id_token\"", q.Get("response_mode"), q.Get("response_type"))
+	}
+	if q.Get("nonce") != authNonce("apple-verifier") {
+		t.Errorf("nonce = %q, want it derived from the session verifier", q.Get("nonce"))
+	}
+}
+
+func TestHandleAuthCallback_AppleValidIDToken(t *testing.T) {
+	s, sign := newTestAppleAuthServer(t)
+	ctx := context.Background()
+	sess, err := s.authSessions.Create(ctx, "apple", "apple-verifier", "cli")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	body := postAppleCallback(t, s, url.Values{
+		"code":     {"apple-code"},
+		"state":    {sess.ID},
+		"id_token": {sign(authNonce("apple-verifier"))},
+	})
+	if !strings.Contains(body, "Authentication Complete") {
+		t.Fatalf("body %q does not contain 'Authentication Complete'", body)
+	}
+	if token, ok, _ := s.authSessions.Consume(ctx, sess.ID); !ok || token != "exchanged-id-token" {
+		t.Errorf("session token = %q (ok=%v), want the exchanged id_token", token, ok)
+	}
+}
+
+func TestHandleAuthCallback_AppleRejectsForgedCallbacks(t *testing.T) {
+	tests := []struct {
+		name    string
+		idToken func(sign func(string) string) string
+	}{
+		{"missing id_token", func(func(string) string) string { return "" }},
+		{"unsigned id_token", func(func(string) string) string { return "forged.token.value" }},
+		{"nonce from another login", func(sign func(string) string) string { return sign(authNonce("someone-else")) }},
+		{"tampered signature", func(sign func(string) string) string {
+			raw := sign(authNonce("apple-verifier"))
+			return raw[:len(raw)-4] + "AAAA"
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, sign := newTestAppleAuthServer(t)
+			ctx := context.Background()
+			sess, err := s.authSessions.Create(ctx, "apple", "apple-verifier", "cli")
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			form := url.Values{"code": {"apple-code"}, "state": {sess.ID}}
+			if tok := tc.idToken(sign); tok != "" {
+				form.Set("id_token", tok)
+			}
+			body := postAppleCallback(t, s, form)
+			if !strings.Contains(body, "Authentication Failed") {
+				t.Errorf("body %q: forged callback was not rejected", body)
+			}
+			if _, ok, _ := s.authSessions.Consume(ctx, sess.ID); ok {
+				t.Error("forged callback completed the auth session")
+			}
+		})
+	}
+}
+
+func TestHandleAuthCallback_AppleRejectsGET(t *testing.T) {
+	s, _ := newTestAppleAuthServer(t)
+	sess, err := s.authSessions.Create(context.Background(), "apple", "apple-verifier", "cli")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/callback?code=c&state="+sess.ID, nil)
+	w := httptest.NewRecorder()
+	s.HandleAuthCallback(w, r)
+
+	if body := w.Body.String(); !strings.Contains(body, "Authentication Failed") {
+		t.Errorf("body %q: GET callback for an Apple session was not rejected", body)
+	}
+}
+
+// --- Explicit-endpoint (GitHub-style) providers ---
+
+// newGitHubStyleVerifier registers a GitHub-shaped provider backed by a mock
+// server: its token endpoint issues "gho_abc" and /user resolves it to
+// octocat.
+func newGitHubStyleVerifier(t *testing.T) *auth.Verifier {
+	t.Helper()
+	mux := http.NewServeMux()
+	gh := httptest.NewServer(mux)
+	t.Cleanup(gh.Close)
+
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/json" {
+			// Real GitHub falls back to form encoding.
+			w.Write([]byte("access_token=gho_form&token_type=bearer"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_abc", "token_type": "bearer"})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gho_abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"login":"octocat","id":583231,"email":"octocat@github.com"}`))
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gho_abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[{"email":"octocat@github.com","primary":true,"verified":true}]`))
+	})
+
+	cfg := auth.GitHubProvider("gh-client", "gh-secret")
+	cfg.AuthURL = gh.URL + "/login/oauth/authorize"
+	cfg.TokenURL = gh.URL + "/login/oauth/access_token"
+	cfg.UserInfoURL = gh.URL + "/user"
+	cfg.EmailsURL = gh.URL + "/user/emails"
+	verifier := auth.NewVerifier(slog.Default())
+	if err := verifier.AddProvider(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	return verifier
+}
+
+func TestHandleAuthCallback_UserInfoProvider(t *testing.T) {
+	verifier := newGitHubStyleVerifier(t)
+	authSessions := NewMemoryAuthSessionStore(5 * time.Minute)
+	t.Cleanup(authSessions.Stop)
+	s := NewServer(slog.Default(), "http://localhost:8080", verifier, false, authSessions, nil, dbstore.NewFake())
+
+	ctx := context.Background()
+	sess, err := s.authSessions.Create(ctx, "github", "test-code-verifier", "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/callback?code=auth-code&state="+sess.ID, nil)
+	w := httptest.NewRecorder()
+	s.HandleAuthCallback(w, r)
+	if body := w.Body.String(); !strings.Contains(body, "Authentication Complete") {
+		t.Fatalf("callback failed: %s", body)
+	}
+
+	token, ok, _ := s.authSessions.Consume(ctx, sess.ID)
+	if !ok || token != "gho_abc" {
+		t.Fatalf("completed token = %q (ok=%v), want gho_abc", token, ok)
+	}
+
+	provider, sub, email, err := s.verifyToken(ctx, token)
+	if err != nil {
+		t.Fatalf("verifyToken: %v", err)
+	}
+	if provider != "github" || sub != "583231" || email != "octocat@github.com" {
+		t.Errorf("identity = %s/%s/%s, want github/583231/octocat@github.com", provider, sub, email)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/google/uuid"
 	"golang.org/x/crypto/ssh"
 
+	"github.com/brporter/phosphor/internal/auth"
 	"github.com/brporter/phosphor/internal/store"
 )
 
@@ -36,6 +37,7 @@ func (s *Server) SetSSHGate(tunnels TunnelDialer, publicAddr string, hostKey ssh
 func (s *Server) resolveUser(r *http.Request) (*store.User, error) {
 	provider, sub, email, err := s.extractIdentity(r)
 	if err != nil {
+		s.metrics.authFailed("api")
 		return nil, err
 	}
 	return s.db.GetOrCreateUser(r.Context(), provider, sub, email)
@@ -67,6 +69,16 @@ func (s *Server) machineJSON(m *store.Machine) machineJSON {
 	}
 }
 
+// writeAuthError reports a failed resolveUser: 403 for valid identities the
+// relay's domain allow-list excludes, 401 for everything else.
+func writeAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrDomainNotAllowed) {
+		writeJSONError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	writeJSONError(w, http.StatusUnauthorized, "authentication required")
+}
+
 func writeJSONError(w http.ResponseWriter, status int, msg string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -78,7 +90,7 @@ func writeJSONError(w http.ResponseWriter, status int, msg string) {
 func (s *Server) HandleListMachines(w http.ResponseWriter, r *http.Request) {
 	user, err := s.resolveUser(r)
 	if err != nil {
-		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		writeAuthError(w, err)
 		return
 	}
 	machines, err := s.db.ListMachines(r.Context(), user.TenantID)
@@ -101,7 +113,7 @@ func (s *Server) HandleListMachines(w http.ResponseWriter, r *http.Request) {
 func (s *Server) HandleCreateMachine(w http.ResponseWriter, r *http.Request) {
 	user, err := s.resolveUser(r)
 	if err != nil {
-		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		writeAuthError(w, err)
 		return
 	}
 	var req struct {
@@ -151,7 +163,7 @@ func (s *Server) HandleCreateMachine(w http.ResponseWriter, r *http.Request) {
 func (s *Server) tenantMachine(w http.ResponseWriter, r *http.Request) (*store.User, *store.Machine, bool) {
 	user, err := s.resolveUser(r)
 	if err != nil {
-		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		writeAuthError(w, err)
 		return nil, nil, false
 	}
 	id, err := uuid.Parse(r.PathValue("id"))
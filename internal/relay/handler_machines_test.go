@@ -235,3 +235,32 @@ func TestMachines_OnlineStatusAndSSHInfo(t *testing.T) {
 		t.Errorf("tunnel close calls = %v", tunnels.closed)
 	}
 }
+
+func TestListMachines_DomainAllowList(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		want    int
+	}{
+		{"allowed", []string{"GitHub.com"}, http.StatusOK},
+		{"disallowed", []string{"example.com"}, http.StatusForbidden},
+		{"no list", nil, http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			verifier := newGitHubStyleVerifier(t)
+			verifier.SetAllowedDomains(tc.domains)
+			authSessions := NewMemoryAuthSessionStore(5 * time.Minute)
+			t.Cleanup(authSessions.Stop)
+			s := NewServer(slog.Default(), "http://test", verifier, false, authSessions, nil, dbstore.NewFake())
+
+			req := httptest.NewRequest(http.MethodGet, "/api/machines", nil)
+			req.Header.Set("Authorization", "Bearer gho_abc")
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, req)
+			if w.Code != tc.want {
+				t.Errorf("status = %d, want %d (body %s)", w.Code, tc.want, w.Body.String())
+			}
+		})
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,40 +15,204 @@ import (
 	"github.com/coder/websocket"
 	"github.com/google/uuid"
 
+	"github.com/brporter/phosphor/internal/auth"
+	"github.com/brporter/phosphor/internal/clock"
 	"github.com/brporter/phosphor/internal/store"
 )
 
 const (
-	// maxBridgesPerMachine caps concurrent browser sessions to one machine.
-	maxBridgesPerMachine = 16
-	// bridgeIdleTimeout closes a session after this long with no traffic.
-	bridgeIdleTimeout = 30 * time.Minute
+	// defaultMaxBridgesPerMachine caps concurrent browser sessions to one
+	// machine when BridgeLimits.MaxPerMachine is unset.
+	defaultMaxBridgesPerMachine = 16
+	// defaultBridgeIdleTimeout closes a session after this long with no
+	// traffic when BridgeLimits.IdleTimeout is unset.
+	defaultBridgeIdleTimeout = 30 * time.Minute
+	// defaultBridgePingInterval and defaultBridgePingTimeout drive the
+	// heartbeat that reaps browsers whose connection died silently.
+	defaultBridgePingInterval = 30 * time.Second
+	defaultBridgePingTimeout  = 10 * time.Second
+	// defaultBridgeAuthReadLimit bounds the auth prelude when
+	// BridgeLimits.MaxAuthMessage is unset. It matches coder/websocket's own
+	// default and leaves room for ID tokens with large group claims.
+	defaultBridgeAuthReadLimit = 32 << 10
+	// bridgeSubprotocol is the WebSocket subprotocol the browser client
+	// must negotiate; anything else is not speaking the bridge protocol.
+	bridgeSubprotocol = "phosphor-ssh"
 )
 
-// bridgeCounts tracks live bridges per machine for the concurrency cap.
+// BridgeLimits bounds the resources browser SSH bridges may use. Zero
+// values mean "no limit" unless noted otherwise.
+type BridgeLimits struct {
+	// MaxTotal caps concurrent bridges across all machines on this relay,
+	// so many sessions collectively can't exhaust the server.
+	MaxTotal int
+	// MaxPerUser caps concurrent bridges one user may hold across all
+	// machines. Dev-mode "dev" identities are exempt, since every
+	// anonymous caller shares one.
+	MaxPerUser int
+	// MaxPerMachine caps concurrent bridges to one machine. Zero means
+	// defaultMaxBridgesPerMachine; unlike the other caps it can't be
+	// disabled, since one machine's sshd shouldn't be flooded.
+	MaxPerMachine int
+	// MaxDuration closes a bridge this long after it opened, however busy
+	// it is, so no session outlives the operator's policy. Zero disables it.
+	MaxDuration time.Duration
+	// IdleTimeout closes a bridge after this long with no traffic in either
+	// direction. Zero means defaultBridgeIdleTimeout; negative disables it.
+	IdleTimeout time.Duration
+	// PingInterval is how often the relay pings the browser. A browser that
+	// doesn't answer within PingTimeout is disconnected. Zero means the
+	// defaults; a negative PingInterval disables the heartbeat.
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+	// MaxAuthMessage caps the size in bytes of the auth prelude, the only
+	// frame the relay buffers whole. A larger prelude is refused with close
+	// code 1009 (message too big). Zero means defaultBridgeAuthReadLimit.
+	// SSH traffic after the prelude is streamed, so frames of any size pass
+	// without being held in memory.
+	MaxAuthMessage int64
+}
+
+func (l BridgeLimits) maxPerMachine() int {
+	if l.MaxPerMachine <= 0 {
+		return defaultMaxBridgesPerMachine
+	}
+	return l.MaxPerMachine
+}
+
+func (l BridgeLimits) idleTimeout() time.Duration {
+	if l.IdleTimeout == 0 {
+		return defaultBridgeIdleTimeout
+	}
+	return l.IdleTimeout
+}
+
+func (l BridgeLimits) maxAuthMessage() int64 {
+	if l.MaxAuthMessage <= 0 {
+		return defaultBridgeAuthReadLimit
+	}
+	return l.MaxAuthMessage
+}
+
+func (l BridgeLimits) pingInterval() time.Duration {
+	if l.PingInterval == 0 {
+		return defaultBridgePingInterval
+	}
+	return l.PingInterval
+}
+
+func (l BridgeLimits) pingTimeout() time.Duration {
+	if l.PingTimeout <= 0 {
+		return defaultBridgePingTimeout
+	}
+	return l.PingTimeout
+}
+
+var (
+	errMachineBusy = errors.New("too many concurrent sessions")
+	errRelayBusy   = errors.New("relay busy")
+	errUserBusy    = errors.New("session limit reached")
+)
+
+// bridgeCounts tracks live bridges per machine, per user and relay-wide for
+// the concurrency caps.
 type bridgeCounts struct {
-	mu sync.Mutex
-	n  map[string]int
+	mu     sync.Mutex
+	n      map[string]int
+	byUser map[string]int
+	total  int
 }
 
-func (b *bridgeCounts) acquire(machineID string) bool {
+// acquire reserves a bridge slot. An empty userID is not counted against
+// MaxPerUser.
+func (b *bridgeCounts) acquire(machineID, userID string, limits BridgeLimits) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	if b.n == nil {
 		b.n = make(map[string]int)
+		b.byUser = make(map[string]int)
+	}
+	if limits.MaxTotal > 0 && b.total >= limits.MaxTotal {
+		return errRelayBusy
+	}
+	if userID != "" && limits.MaxPerUser > 0 && b.byUser[userID] >= limits.MaxPerUser {
+		return errUserBusy
 	}
-	if b.n[machineID] >= maxBridgesPerMachine {
-		return false
+	if limit := limits.maxPerMachine(); b.n[machineID] >= limit {
+		return fmt.Errorf("%w (limit %d)", errMachineBusy, limit)
 	}
 	b.n[machineID]++
-	return true
+	if userID != "" {
+		b.byUser[userID]++
+	}
+	b.total++
+	return nil
 }
 
-func (b *bridgeCounts) release(machineID string) {
+func (b *bridgeCounts) release(machineID, userID string) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	if b.n[machineID] > 0 {
 		b.n[machineID]--
+		b.total--
+	}
+	if b.byUser[userID] > 0 {
+		b.byUser[userID]--
+		if b.byUser[userID] == 0 {
+			delete(b.byUser, userID)
+		}
+	}
+}
+
+// SetBridgeLimits configures the SSH bridge resource limits.
+func (s *Server) SetBridgeLimits(limits BridgeLimits) {
+	s.bridgeLimits = limits
+}
+
+// SetAllowedOrigins adds origin host patterns (path.Match syntax, e.g.
+// "*.example.com") that may open browser bridges, beyond the relay's own
+// BASE_URL host and same-host requests.
+func (s *Server) SetAllowedOrigins(patterns []string) {
+	s.allowedOrigins = patterns
+}
+
+// bridgeAcceptOptions builds the upgrade policy for browser bridges. Without
+// an origin check any website could open a bridge with a logged-in user's
+// token in hand, so only the relay's own origins pass. Dev mode accepts any
+// origin so frontend dev servers on other ports work.
+func (s *Server) bridgeAcceptOptions() *websocket.AcceptOptions {
+	opts := &websocket.AcceptOptions{Subprotocols: []string{bridgeSubprotocol}}
+	if s.devMode {
+		opts.InsecureSkipVerify = true
+		return opts
+	}
+	opts.OriginPatterns = append(opts.OriginPatterns, s.allowedOrigins...)
+	if u, err := url.Parse(s.baseURL); err == nil && u.Host != "" {
+		opts.OriginPatterns = append(opts.OriginPatterns, u.Host)
+	}
+	return opts
+}
+
+// CloseBridges ends every open browser bridge with a going-away close and
+// waits, until ctx is done, for them to unwind. Bridges accepted afterwards
+// are closed as soon as they open. Call it before http.Server.Shutdown,
+// which doesn't track hijacked connections.
+func (s *Server) CloseBridges(ctx context.Context) error {
+	s.closeBridge()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.bridges.mu.Lock()
+		open := s.bridges.total
+		s.bridges.mu.Unlock()
+		if open == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d bridges still open: %w", open, ctx.Err())
+		case <-ticker.C:
+		}
 	}
 }
 
@@ -62,20 +228,30 @@ func (s *Server) HandleSSHBridge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	machineID := r.PathValue("machineID")
+	noteMachine(r.Context(), machineID)
 
-	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		Subprotocols: []string{"phosphor-ssh"},
-	})
+	// A mismatched Origin is refused with 403 inside Accept.
+	conn, err := websocket.Accept(w, r, s.bridgeAcceptOptions())
 	if err != nil {
 		s.logger.Debug("accept ssh bridge ws", "err", err)
 		return
 	}
 	defer conn.CloseNow()
 
+	// Accept succeeds even when the client offered no subprotocol, so
+	// enforce it here before reading anything.
+	if conn.Subprotocol() != bridgeSubprotocol {
+		conn.Close(websocket.StatusPolicyViolation, "unsupported subprotocol")
+		return
+	}
+
 	// Auth prelude: {"token": "..."} as the first message.
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
+	// An oversized prelude makes coder/websocket send the 1009 close
+	// itself, so the close below is then a no-op.
+	conn.SetReadLimit(s.bridgeLimits.maxAuthMessage())
 	authCtx, authCancel := context.WithTimeout(ctx, 10*time.Second)
 	_, data, err := conn.Read(authCtx)
 	authCancel()
@@ -91,11 +267,17 @@ func (s *Server) HandleSSHBridge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	provider, sub, email, err := s.verifyToken(ctx, authMsg.Token)
+	provider, sub, email, err := s.identify(ctx, r, authMsg.Token)
 	if err != nil {
-		conn.Close(websocket.StatusPolicyViolation, "authentication failed")
+		s.metrics.authFailed("bridge")
+		reason := "authentication failed"
+		if errors.Is(err, auth.ErrDomainNotAllowed) {
+			reason = "forbidden"
+		}
+		conn.Close(websocket.StatusPolicyViolation, reason)
 		return
 	}
+	noteIdentity(ctx, provider, sub)
 	user, err := s.db.GetOrCreateUser(ctx, provider, sub, email)
 	if err != nil {
 		s.logger.Error("resolving user for ssh bridge", "err", err)
@@ -119,15 +301,28 @@ func (s *Server) HandleSSHBridge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !s.bridges.acquire(machineID) {
-		conn.Close(websocket.StatusTryAgainLater, "too many concurrent sessions")
+	userKey := user.ID.String()
+	if provider == "dev" {
+		userKey = ""
+	}
+	if err := s.bridges.acquire(machineID, userKey, s.bridgeLimits); err != nil {
+		switch {
+		case errors.Is(err, errRelayBusy):
+			s.metrics.bridgeRefused("relay_busy")
+		case errors.Is(err, errUserBusy):
+			s.metrics.bridgeRefused("user_busy")
+		default:
+			s.metrics.bridgeRefused("machine_busy")
+		}
+		conn.Close(websocket.StatusTryAgainLater, err.Error())
 		return
 	}
-	defer s.bridges.release(machineID)
+	defer s.bridges.release(machineID, userKey)
 
 	tunnelConn, err := s.tunnels.Dial(machineID)
 	if err != nil {
 		s.logger.Info("ssh bridge dial failed", "machine", machineID, "err", err)
+		s.metrics.bridgeRefused("offline")
 		conn.Close(websocket.StatusTryAgainLater, "machine offline")
 		return
 	}
@@ -138,16 +333,83 @@ func (s *Server) HandleSSHBridge(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Relay shutdown: tell the browser why, then unwind like any other end.
+	stopClosing := context.AfterFunc(s.closing, func() {
+		conn.Close(websocket.StatusGoingAway, "relay shutting down")
+		cancel()
+	})
+	defer stopClosing()
+
+	if d := s.bridgeLimits.MaxDuration; d > 0 {
+		expired := s.clock.After(d)
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-expired:
+				s.logger.Info("ssh bridge max duration reached", "machine", machineID, "user", user.ID, "max", d)
+				conn.Close(websocket.StatusGoingAway, "max session duration reached")
+				cancel()
+			}
+		}()
+	}
+
 	s.logger.Info("ssh bridge open", "machine", machineID, "user", user.ID)
-	wsConn := websocket.NetConn(ctx, conn, websocket.MessageBinary)
-	pipe(ctx, wsConn, tunnelConn, cancel)
+	s.metrics.bridgeOpened()
+	defer s.metrics.bridgeClosed()
+	if interval := s.bridgeLimits.pingInterval(); interval > 0 {
+		go heartbeat(ctx, conn, s.clock, interval, s.bridgeLimits.pingTimeout(), func() {
+			// The peer is gone, so don't wait on a close handshake.
+			s.logger.Info("ssh bridge ping timeout", "machine", machineID, "user", user.ID)
+			conn.CloseNow()
+			cancel()
+		})
+	}
+	wsConn := s.metrics.countReads(websocket.NetConn(ctx, conn, websocket.MessageBinary), "upstream")
+	pipe(ctx, wsConn, s.metrics.countReads(tunnelConn, "downstream"), cancel, s.clock, s.bridgeLimits.idleTimeout(), func() {
+		s.logger.Info("ssh bridge idle", "machine", machineID, "user", user.ID)
+		conn.Close(websocket.StatusGoingAway, "idle timeout")
+	})
 	s.logger.Info("ssh bridge closed", "machine", machineID, "user", user.ID)
 	conn.Close(websocket.StatusNormalClosure, "session ended")
 }
 
+// heartbeat pings conn every interval until ctx ends. If a pong doesn't
+// arrive within timeout it calls onDead and stops. Pongs are handled by
+// conn's reader, which the bridge's pipe keeps running.
+func heartbeat(ctx context.Context, conn *websocket.Conn, clk clock.Clock, interval, timeout time.Duration, onDead func()) {
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			pingCtx, pingCancel := context.WithCancel(ctx)
+			done := make(chan error, 1)
+			go func() { done <- conn.Ping(pingCtx) }()
+			var err error
+			select {
+			case err = <-done:
+			case <-clk.After(timeout):
+				pingCancel()
+				err = <-done
+			}
+			pingCancel()
+			if err != nil {
+				if ctx.Err() == nil {
+					onDead()
+				}
+				return
+			}
+		}
+	}
+}
+
 // pipe copies bytes both ways until either side closes or the session goes
-// idle, then cancels ctx so both copies unwind.
-func pipe(ctx context.Context, a, b net.Conn, cancel context.CancelFunc) {
+// idle for longer than idle (never, if idle <= 0), then cancels ctx so both
+// copies unwind. onIdle runs first when the idle watchdog fires, so the
+// caller can tell the peer why before the conns are torn down.
+func pipe(ctx context.Context, a, b net.Conn, cancel context.CancelFunc, clk clock.Clock, idle time.Duration, onIdle func()) {
 	var active atomic.Bool
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -172,23 +434,26 @@ func pipe(ctx context.Context, a, b net.Conn, cancel context.CancelFunc) {
 	go copyOne(b, a)
 
 	// Idle watchdog.
-	go func() {
-		ticker := time.NewTicker(bridgeIdleTimeout)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				if !active.Swap(false) {
-					cancel()
-					a.Close()
-					b.Close()
+	if idle > 0 {
+		go func() {
+			ticker := clk.NewTicker(idle)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
 					return
+				case <-ticker.C():
+					if !active.Swap(false) {
+						onIdle()
+						cancel()
+						a.Close()
+						b.Close()
+						return
+					}
 				}
 			}
-		}
-	}()
+		}()
+	}
 
 	<-ctx.Done()
 	a.Close()
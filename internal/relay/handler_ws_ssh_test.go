@@ -5,9 +5,12 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
@@ -15,8 +18,10 @@ import (
 	"time"
 
 	"github.com/coder/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"golang.org/x/crypto/ssh"
 
+	"github.com/brporter/phosphor/internal/clock"
 	dbstore "github.com/brporter/phosphor/internal/store"
 )
 
@@ -28,6 +33,16 @@ type stubTunnels struct {
 
 func (s *stubTunnels) Online(id string) bool { return s.online[id] }
 func (s *stubTunnels) Close(id string) bool  { return false }
+func (s *stubTunnels) Connects() uint64      { return uint64(s.Count()) }
+func (s *stubTunnels) Count() int {
+	n := 0
+	for _, on := range s.online {
+		if on {
+			n++
+		}
+	}
+	return n
+}
 func (s *stubTunnels) Dial(id string) (net.Conn, error) {
 	if !s.online[id] {
 		return nil, net.ErrClosed
@@ -41,6 +56,18 @@ func (s *stubTunnels) Dial(id string) (net.Conn, error) {
 }
 
 func newBridgeServer(t *testing.T, online bool) (*httptest.Server, string) {
+	t.Helper()
+	return newBridgeServerWithLimits(t, online, BridgeLimits{})
+}
+
+func newBridgeServerWithLimits(t *testing.T, online bool, limits BridgeLimits) (*httptest.Server, string) {
+	t.Helper()
+	return newBridgeServerWith(t, online, func(s *Server) { s.SetBridgeLimits(limits) })
+}
+
+// newBridgeServerWith builds a bridge test server, letting configure adjust
+// the Server before its handler is built.
+func newBridgeServerWith(t *testing.T, online bool, configure func(*Server)) (*httptest.Server, string) {
 	t.Helper()
 	authSessions := NewMemoryAuthSessionStore(5 * time.Minute)
 	t.Cleanup(authSessions.Stop)
@@ -61,6 +88,7 @@ func newBridgeServer(t *testing.T, online bool) (*httptest.Server, string) {
 	hostPub, _, _ := ed25519.GenerateKey(rand.Reader)
 	hk, _ := ssh.NewPublicKey(hostPub)
 	s.SetSSHGate(&stubTunnels{online: map[string]bool{m.ID.String(): online}}, "relay:2222", hk)
+	configure(s)
 
 	ts := httptest.NewServer(s.Handler())
 	t.Cleanup(ts.Close)
@@ -153,7 +181,7 @@ func TestSSHBridge_ConcurrencyCap(t *testing.T) {
 	var mu sync.Mutex
 	accepted := 0
 	// Open more than the cap; excess should be rejected.
-	for i := 0; i < maxBridgesPerMachine+3; i++ {
+	for i := 0; i < defaultMaxBridgesPerMachine+3; i++ {
 		conn := dialBridge(t, ts, machineID)
 		conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
 		_, data, err := conn.Read(ctx)
@@ -169,10 +197,493 @@ func TestSSHBridge_ConcurrencyCap(t *testing.T) {
 	for _, c := range conns {
 		c.CloseNow()
 	}
-	if accepted > maxBridgesPerMachine {
-		t.Errorf("accepted %d bridges, cap is %d", accepted, maxBridgesPerMachine)
+	if accepted > defaultMaxBridgesPerMachine {
+		t.Errorf("accepted %d bridges, cap is %d", accepted, defaultMaxBridgesPerMachine)
 	}
 	if accepted == 0 {
 		t.Error("expected some bridges to be accepted")
 	}
 }
+
+func TestSSHBridge_RejectsMissingSubprotocol(t *testing.T) {
+	ts, machineID := newBridgeServer(t, true)
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/ssh/" + machineID
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial bridge: %v", err)
+	}
+	defer conn.CloseNow()
+
+	conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	_, _, err = conn.Read(ctx)
+	if websocket.CloseStatus(err) != websocket.StatusPolicyViolation {
+		t.Fatalf("expected policy-violation close without subprotocol, got %v", err)
+	}
+}
+
+func TestSSHBridge_RelayWideCap(t *testing.T) {
+	const limit = 3
+	ts, machineID := newBridgeServerWithLimits(t, true, BridgeLimits{MaxTotal: limit})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var conns []*websocket.Conn
+	accepted, busy := 0, 0
+	for i := 0; i < limit+2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := dialBridge(t, ts, machineID)
+			conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+			_, data, err := conn.Read(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			conns = append(conns, conn)
+			switch {
+			case err == nil && strings.Contains(string(data), `"ok":true`):
+				accepted++
+			case websocket.CloseStatus(err) == websocket.StatusTryAgainLater:
+				busy++
+			}
+		}()
+	}
+	wg.Wait()
+	for _, c := range conns {
+		c.CloseNow()
+	}
+	if accepted != limit {
+		t.Errorf("accepted %d bridges, want %d", accepted, limit)
+	}
+	if busy != 2 {
+		t.Errorf("%d bridges refused as busy, want 2", busy)
+	}
+}
+
+func TestBridgeCounts_ReleaseFreesRelaySlot(t *testing.T) {
+	var b bridgeCounts
+	limits := BridgeLimits{MaxTotal: 1}
+	if err := b.acquire("a", "u1", limits); err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	if err := b.acquire("b", "u1", limits); !errors.Is(err, errRelayBusy) {
+		t.Fatalf("second acquire = %v, want errRelayBusy", err)
+	}
+	b.release("a", "u1")
+	if err := b.acquire("b", "u1", limits); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+}
+
+func TestBridgeCounts_PerMachineCap(t *testing.T) {
+	for _, tc := range []struct {
+		max, want int
+	}{
+		{0, defaultMaxBridgesPerMachine},
+		{1, 1},
+		{3, 3},
+		{defaultMaxBridgesPerMachine + 4, defaultMaxBridgesPerMachine + 4},
+	} {
+		var b bridgeCounts
+		limits := BridgeLimits{MaxPerMachine: tc.max}
+		for i := range tc.want {
+			if err := b.acquire("m", "", limits); err != nil {
+				t.Fatalf("max %d: acquire %d: %v", tc.max, i, err)
+			}
+		}
+		err := b.acquire("m", "", limits)
+		if !errors.Is(err, errMachineBusy) || !strings.Contains(err.Error(), fmt.Sprintf("limit %d", tc.want)) {
+			t.Errorf("max %d: over-cap acquire = %v", tc.max, err)
+		}
+		if err := b.acquire("other", "", limits); err != nil {
+			t.Errorf("max %d: other machine should be unaffected: %v", tc.max, err)
+		}
+	}
+}
+
+func TestSSHBridge_MaxPerMachine(t *testing.T) {
+	ts, machineID := newBridgeServerWithLimits(t, true, BridgeLimits{MaxPerMachine: 2})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := range 2 {
+		conn := dialBridge(t, ts, machineID)
+		defer conn.CloseNow()
+		conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+		if _, data, err := conn.Read(ctx); err != nil || !strings.Contains(string(data), `"ok":true`) {
+			t.Fatalf("bridge %d: ack = %q, %v", i, data, err)
+		}
+	}
+
+	conn := dialBridge(t, ts, machineID)
+	defer conn.CloseNow()
+	conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	_, _, err := conn.Read(ctx)
+	var ce websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.StatusTryAgainLater || !strings.Contains(ce.Reason, "limit 2") {
+		t.Fatalf("third bridge: got %v, want try-again close reporting limit 2", err)
+	}
+}
+
+func TestBridgeCounts_PerUserCap(t *testing.T) {
+	var b bridgeCounts
+	limits := BridgeLimits{MaxPerUser: 2}
+	for _, m := range []string{"a", "b"} {
+		if err := b.acquire(m, "u1", limits); err != nil {
+			t.Fatalf("acquire %s: %v", m, err)
+		}
+	}
+	if err := b.acquire("c", "u1", limits); !errors.Is(err, errUserBusy) {
+		t.Fatalf("third acquire = %v, want errUserBusy", err)
+	}
+	if err := b.acquire("c", "u2", limits); err != nil {
+		t.Fatalf("other user should be unaffected: %v", err)
+	}
+	for range 5 {
+		if err := b.acquire("c", "", limits); err != nil {
+			t.Fatalf("exempt identity should not be capped: %v", err)
+		}
+	}
+}
+
+func TestSSHBridge_PerUserCap(t *testing.T) {
+	const limit = 2
+	ts, machineID := newBridgeServerWithLimits(t, true, BridgeLimits{MaxPerUser: limit})
+	ctx := context.Background()
+
+	var conns []*websocket.Conn
+	defer func() {
+		for _, c := range conns {
+			c.CloseNow()
+		}
+	}()
+	for i := 0; i < limit; i++ {
+		conn := dialBridge(t, ts, machineID)
+		conns = append(conns, conn)
+		conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+		if _, data, err := conn.Read(ctx); err != nil || !strings.Contains(string(data), `"ok":true`) {
+			t.Fatalf("bridge %d: ack = %q, %v", i, data, err)
+		}
+	}
+
+	extra := dialBridge(t, ts, machineID)
+	conns = append(conns, extra)
+	extra.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	_, _, err := extra.Read(ctx)
+	var ce websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.StatusTryAgainLater || ce.Reason != errUserBusy.Error() {
+		t.Fatalf("expected session-limit close, got %v", err)
+	}
+
+	// Existing bridges keep working.
+	if err := conns[0].Write(ctx, websocket.MessageBinary, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, data, err := conns[0].Read(ctx); err != nil || string(data) != "ping" {
+		t.Fatalf("echo on existing bridge = %q, %v", data, err)
+	}
+}
+
+func TestSSHBridge_IdleTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	ts, machineID := newBridgeServerWith(t, true, func(s *Server) {
+		s.clock = clk
+		s.SetBridgeLimits(BridgeLimits{IdleTimeout: 30 * time.Minute, PingInterval: -1})
+	})
+	conn := dialBridge(t, ts, machineID)
+	defer conn.CloseNow()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	if _, data, err := conn.Read(ctx); err != nil || !strings.Contains(string(data), `"ok":true`) {
+		t.Fatalf("ack = %q, %v", data, err)
+	}
+
+	// The idle watchdog's ticker is the only timer; one silent period
+	// closes the bridge.
+	clk.BlockUntil(1)
+	clk.Advance(30 * time.Minute)
+
+	_, _, err := conn.Read(ctx)
+	var ce websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.StatusGoingAway || ce.Reason != "idle timeout" {
+		t.Fatalf("expected idle-timeout close, got %v", err)
+	}
+}
+
+func TestSSHBridge_PingTimeoutReapsDeadBrowser(t *testing.T) {
+	var srv *Server
+	ts, machineID := newBridgeServerWith(t, true, func(s *Server) {
+		srv = s
+		s.SetBridgeLimits(BridgeLimits{PingInterval: 20 * time.Millisecond, PingTimeout: 50 * time.Millisecond})
+		s.EnableMetrics()
+	})
+	conn := dialBridge(t, ts, machineID)
+	defer conn.CloseNow()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	if _, data, err := conn.Read(ctx); err != nil || !strings.Contains(string(data), `"ok":true`) {
+		t.Fatalf("ack = %q, %v", data, err)
+	}
+
+	// Stop reading: coder/websocket only answers pings from inside Read, so
+	// this browser now looks dead to the relay.
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		srv.bridges.mu.Lock()
+		total := srv.bridges.total
+		srv.bridges.mu.Unlock()
+		if total == 0 && testutil.ToFloat64(srv.metrics.bridgesActive) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bridge not reaped: %d slots held", total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSSHBridge_PingKeepsLiveBrowser(t *testing.T) {
+	ts, machineID := newBridgeServerWithLimits(t, true, BridgeLimits{PingInterval: 10 * time.Millisecond, PingTimeout: time.Second})
+	conn := dialBridge(t, ts, machineID)
+	defer conn.CloseNow()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	if _, data, err := conn.Read(ctx); err != nil || !strings.Contains(string(data), `"ok":true`) {
+		t.Fatalf("ack = %q, %v", data, err)
+	}
+
+	// Several ping intervals pass while Read answers pongs; the echo must
+	// still come back afterwards.
+	time.Sleep(100 * time.Millisecond)
+	if err := conn.Write(ctx, websocket.MessageBinary, []byte("ping?")); err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := conn.Read(ctx)
+	if err != nil || string(data) != "ping?" {
+		t.Fatalf("echo = %q, %v", data, err)
+	}
+}
+
+func TestSSHBridge_OriginCheck(t *testing.T) {
+	ts, machineID := newBridgeServerWith(t, true, func(s *Server) {
+		s.devMode = false
+		s.baseURL = "https://phosphor.example.com"
+		s.SetAllowedOrigins([]string{"*.trusted.example"})
+	})
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/ssh/" + machineID
+
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"https://evil.example", false},
+		{"https://phosphor.example.com", true},
+		{"https://app.trusted.example", true},
+		{ts.URL, true}, // same host as the request
+	}
+	for _, tc := range tests {
+		t.Run(tc.origin, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, resp, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{
+				Subprotocols: []string{bridgeSubprotocol},
+				HTTPHeader:   http.Header{"Origin": []string{tc.origin}},
+			})
+			if tc.ok {
+				if err != nil {
+					t.Fatalf("dial: %v", err)
+				}
+				conn.CloseNow()
+				return
+			}
+			if err == nil {
+				conn.CloseNow()
+				t.Fatal("upgrade from forbidden origin succeeded")
+			}
+			if resp == nil || resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("resp = %v, err = %v; want 403", resp, err)
+			}
+		})
+	}
+}
+
+func TestSSHBridge_DevModeSkipsOriginCheck(t *testing.T) {
+	ts, machineID := newBridgeServer(t, true)
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/ssh/" + machineID
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{
+		Subprotocols: []string{bridgeSubprotocol},
+		HTTPHeader:   http.Header{"Origin": []string{"http://localhost:3000"}},
+	})
+	if err != nil {
+		t.Fatalf("dev-mode dial from another origin: %v", err)
+	}
+	conn.CloseNow()
+}
+
+func TestCloseBridges_DrainsOpenBridges(t *testing.T) {
+	var srv *Server
+	ts, machineID := newBridgeServerWith(t, true, func(s *Server) { srv = s })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var conns []*websocket.Conn
+	for range 2 {
+		conn := dialBridge(t, ts, machineID)
+		defer conn.CloseNow()
+		conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+		if _, data, err := conn.Read(ctx); err != nil || !strings.Contains(string(data), `"ok":true`) {
+			t.Fatalf("ack = %q, %v", data, err)
+		}
+		conns = append(conns, conn)
+	}
+
+	// Browsers keep reading, as real ones do, so the close handshake
+	// completes.
+	errs := make(chan error, len(conns))
+	for _, c := range conns {
+		go func() {
+			_, _, err := c.Read(ctx)
+			errs <- err
+		}()
+	}
+
+	if err := srv.CloseBridges(ctx); err != nil {
+		t.Fatalf("CloseBridges: %v", err)
+	}
+	for range conns {
+		err := <-errs
+		var ce websocket.CloseError
+		if !errors.As(err, &ce) || ce.Code != websocket.StatusGoingAway || ce.Reason != "relay shutting down" {
+			t.Errorf("expected going-away close, got %v", err)
+		}
+	}
+
+	// New bridges after shutdown are closed straight away.
+	conn := dialBridge(t, ts, machineID)
+	defer conn.CloseNow()
+	conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	for {
+		_, _, err := conn.Read(ctx)
+		if err != nil {
+			var ce websocket.CloseError
+			if !errors.As(err, &ce) || ce.Code != websocket.StatusGoingAway {
+				t.Errorf("post-shutdown bridge: got %v, want going-away close", err)
+			}
+			break
+		}
+	}
+}
+
+func TestSSHBridge_OversizedAuthMessage(t *testing.T) {
+	ts, machineID := newBridgeServerWithLimits(t, true, BridgeLimits{MaxAuthMessage: 1 << 10})
+	conn := dialBridge(t, ts, machineID)
+	defer conn.CloseNow()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	big := `{"token":"` + strings.Repeat("x", 2<<10) + `"}`
+	conn.Write(ctx, websocket.MessageText, []byte(big))
+	_, _, err := conn.Read(ctx)
+	if websocket.CloseStatus(err) != websocket.StatusMessageTooBig {
+		t.Fatalf("expected message-too-big close, got %v", err)
+	}
+}
+
+func TestSSHBridge_LargeFramesAfterAuth(t *testing.T) {
+	ts, machineID := newBridgeServerWithLimits(t, true, BridgeLimits{MaxAuthMessage: 1 << 10})
+	conn := dialBridge(t, ts, machineID)
+	defer conn.CloseNow()
+	conn.SetReadLimit(-1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	if _, data, err := conn.Read(ctx); err != nil || !strings.Contains(string(data), `"ok":true`) {
+		t.Fatalf("ack = %q, %v", data, err)
+	}
+
+	// The auth limit doesn't apply to streamed SSH traffic.
+	payload := make([]byte, 1<<20)
+	if err := conn.Write(ctx, websocket.MessageBinary, payload); err != nil {
+		t.Fatal(err)
+	}
+	got := 0
+	for got < len(payload) {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			t.Fatalf("read echo after %d bytes: %v", got, err)
+		}
+		got += len(data)
+	}
+}
+
+func TestSSHBridge_DevIdentityHeader(t *testing.T) {
+	ts, machineID := newBridgeServer(t, true)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The token names the owner, but the header switches to another user,
+	// who doesn't own the machine.
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws/ssh/" + machineID
+	conn, _, err := websocket.Dial(ctx, wsURL, &websocket.DialOptions{
+		Subprotocols: []string{"phosphor-ssh"},
+		HTTPHeader:   http.Header{devIdentityHeader: {"google:mallory"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseNow()
+
+	conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	_, _, err = conn.Read(ctx)
+	var ce websocket.CloseError
+	if !errors.As(err, &ce) || ce.Reason != "machine not found" {
+		t.Fatalf("expected machine-not-found close for the header's user, got %v", err)
+	}
+}
+
+func TestSSHBridge_MaxDuration(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	ts, machineID := newBridgeServerWith(t, true, func(s *Server) {
+		s.clock = clk
+		s.SetBridgeLimits(BridgeLimits{MaxDuration: time.Hour, IdleTimeout: -1, PingInterval: -1})
+	})
+	conn := dialBridge(t, ts, machineID)
+	defer conn.CloseNow()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	if _, data, err := conn.Read(ctx); err != nil || !strings.Contains(string(data), `"ok":true`) {
+		t.Fatalf("ack = %q, %v", data, err)
+	}
+	clk.BlockUntil(1)
+
+	// Just short of the cap, the session still carries traffic.
+	clk.Advance(time.Hour - time.Second)
+	if err := conn.Write(ctx, websocket.MessageBinary, []byte("x")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, data, err := conn.Read(ctx); err != nil || string(data) != "x" {
+		t.Fatalf("echo before the cap = %q, %v", data, err)
+	}
+
+	// Busy or not, reaching the cap closes it.
+	clk.Advance(time.Second)
+	_, _, err := conn.Read(ctx)
+	var ce websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.StatusGoingAway || ce.Reason != "max session duration reached" {
+		t.Fatalf("expected max-duration close, got %v", err)
+	}
+}
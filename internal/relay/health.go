@@ -0,0 +1,66 @@
+package relay
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SetExpectedProviders names the identity providers the deployment
+// configured; /readyz reports not-ready until all of them registered, so a
+// provider whose discovery failed at startup doesn't go unnoticed.
+func (s *Server) SetExpectedProviders(names []string) {
+	s.expectedProviders = names
+}
+
+// BeginShutdown marks the relay as draining: /readyz starts failing so load
+// balancers stop sending new traffic, while /healthz stays ok.
+func (s *Server) BeginShutdown() {
+	s.shuttingDown.Store(true)
+}
+
+// HandleHealthz is the liveness probe: ok whenever the process is serving.
+// GET /healthz (and the older /health)
+func (s *Server) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// HandleReadyz is the readiness probe. It returns 503 with the reason while
+// the relay is shutting down, a configured provider is missing, or the SSH
+// gateway isn't wired.
+// GET /readyz
+func (s *Server) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	if reason := s.notReadyReason(); reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(reason))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func (s *Server) notReadyReason() string {
+	if s.shuttingDown.Load() {
+		return "shutting down"
+	}
+	if s.tunnels == nil {
+		return "ssh gateway not configured"
+	}
+	var missing []string
+	for _, name := range s.expectedProviders {
+		if s.verifier == nil {
+			missing = append(missing, name)
+			continue
+		}
+		if _, ok := s.verifier.GetProvider(name); !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "providers not registered: " + strings.Join(missing, ", ")
+	}
+	if !s.devMode && (s.verifier == nil || len(s.verifier.ProviderNames()) == 0) {
+		return "no identity providers registered"
+	}
+	return ""
+}
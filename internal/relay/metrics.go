@@ -0,0 +1,150 @@
+package relay
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// tunnelStats is the optional part of TunnelDialer that metrics read
+// (implemented by *sshgate.Registry).
+type tunnelStats interface {
+	Count() int
+	Connects() uint64
+}
+
+// sweepStats is the optional part of AuthSessionStoreI that metrics read
+// (implemented by *MemoryAuthSessionStore).
+type sweepStats interface {
+	Swept() int64
+}
+
+// metrics holds the relay's Prometheus collectors. A nil *metrics is valid
+// and records nothing, so servers built without EnableMetrics pay no cost.
+type metrics struct {
+	registry       *prometheus.Registry
+	bridgesActive  prometheus.Gauge
+	bridgesOpened  prometheus.Counter
+	bridgesRefused *prometheus.CounterVec
+	bridgeBytes    *prometheus.CounterVec
+	authFailures   *prometheus.CounterVec
+}
+
+// EnableMetrics registers the relay's collectors and serves them at
+// GET /metrics. Call it before Handler.
+func (s *Server) EnableMetrics() {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		bridgesActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "phosphor_bridges_active",
+			Help: "Browser SSH bridges currently open.",
+		}),
+		bridgesOpened: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "phosphor_bridges_opened_total",
+			Help: "Browser SSH bridges opened since start.",
+		}),
+		bridgesRefused: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "phosphor_bridges_refused_total",
+			Help: "Browser SSH bridges refused after authentication, by reason.",
+		}, []string{"reason"}),
+		bridgeBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "phosphor_bridge_bytes_total",
+			Help: "Bytes piped through browser SSH bridges, by direction.",
+		}, []string{"direction"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "phosphor_auth_failures_total",
+			Help: "Rejected credentials, by the surface that rejected them.",
+		}, []string{"source"}),
+	}
+	m.registry.MustRegister(m.bridgesActive, m.bridgesOpened, m.bridgesRefused, m.bridgeBytes, m.authFailures)
+
+	// Tunnel figures come from the gateway at scrape time, so it doesn't
+	// matter whether SetSSHGate runs before or after this.
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "phosphor_tunnels_online",
+			Help: "Machines with a live reverse tunnel.",
+		}, func() float64 {
+			if ts, ok := s.tunnels.(tunnelStats); ok {
+				return float64(ts.Count())
+			}
+			return 0
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "phosphor_tunnel_connects_total",
+			Help: "Reverse tunnels registered since start, including reconnects.",
+		}, func() float64 {
+			if ts, ok := s.tunnels.(tunnelStats); ok {
+				return float64(ts.Connects())
+			}
+			return 0
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "phosphor_auth_sessions_swept_total",
+			Help: "Expired login sessions removed by the background sweep.",
+		}, func() float64 {
+			if ss, ok := s.authSessions.(sweepStats); ok {
+				return float64(ss.Swept())
+			}
+			return 0
+		}),
+	)
+	s.metrics = m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *metrics) bridgeOpened() {
+	if m == nil {
+		return
+	}
+	m.bridgesOpened.Inc()
+	m.bridgesActive.Inc()
+}
+
+func (m *metrics) bridgeClosed() {
+	if m == nil {
+		return
+	}
+	m.bridgesActive.Dec()
+}
+
+func (m *metrics) bridgeRefused(reason string) {
+	if m == nil {
+		return
+	}
+	m.bridgesRefused.WithLabelValues(reason).Inc()
+}
+
+func (m *metrics) authFailed(source string) {
+	if m == nil {
+		return
+	}
+	m.authFailures.WithLabelValues(source).Inc()
+}
+
+// countReads wraps c so bytes read from it are added to the bridge byte
+// counter for direction. It returns c unchanged when metrics are off.
+func (m *metrics) countReads(c net.Conn, direction string) net.Conn {
+	if m == nil {
+		return c
+	}
+	return &countingConn{Conn: c, n: m.bridgeBytes.WithLabelValues(direction)}
+}
+
+type countingConn struct {
+	net.Conn
+	n prometheus.Counter
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.n.Add(float64(n))
+	}
+	return n, err
+}
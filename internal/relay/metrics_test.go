@@ -0,0 +1,113 @@
+package relay
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/brporter/phosphor/internal/clock"
+	dbstore "github.com/brporter/phosphor/internal/store"
+)
+
+func scrapeMetrics(t *testing.T, ts *httptest.Server) string {
+	t.Helper()
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics status = %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestMetrics_BridgeLifecycle(t *testing.T) {
+	ts, machineID := newBridgeServerWith(t, true, func(s *Server) { s.EnableMetrics() })
+	ctx := context.Background()
+
+	if body := scrapeMetrics(t, ts); !strings.Contains(body, "phosphor_bridges_active 0") ||
+		!strings.Contains(body, "phosphor_tunnels_online 1") {
+		t.Fatalf("unexpected initial metrics:\n%s", body)
+	}
+
+	conn := dialBridge(t, ts, machineID)
+	defer conn.CloseNow()
+	conn.Write(ctx, websocket.MessageText, []byte(`{"token":"google:alice"}`))
+	if _, data, err := conn.Read(ctx); err != nil || !strings.Contains(string(data), `"ok":true`) {
+		t.Fatalf("ack = %q, %v", data, err)
+	}
+	conn.Write(ctx, websocket.MessageBinary, []byte("ping"))
+	if _, _, err := conn.Read(ctx); err != nil {
+		t.Fatalf("read echo: %v", err)
+	}
+
+	body := scrapeMetrics(t, ts)
+	for _, want := range []string{
+		"phosphor_bridges_active 1",
+		"phosphor_bridges_opened_total 1",
+		`phosphor_bridge_bytes_total{direction="upstream"} 4`,
+		`phosphor_bridge_bytes_total{direction="downstream"} 4`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetrics_AuthSessionsSwept(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	authSessions := newMemoryAuthSessionStore(time.Minute, 0, nil, clk)
+	t.Cleanup(authSessions.Stop)
+	s := NewServer(slog.Default(), "http://test", nil, true, authSessions, nil, dbstore.NewFake())
+	s.EnableMetrics()
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(ts.Close)
+
+	for range 2 {
+		if _, err := authSessions.Create(context.Background(), "google", "verifier", "cli"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if body := scrapeMetrics(t, ts); !strings.Contains(body, "phosphor_auth_sessions_swept_total 0") {
+		t.Fatalf("unexpected initial metrics:\n%s", body)
+	}
+
+	clk.Advance(2 * time.Minute)
+	authSessions.sweep(clk.Now())
+	if body := scrapeMetrics(t, ts); !strings.Contains(body, "phosphor_auth_sessions_swept_total 2") {
+		t.Errorf("metrics missing swept sessions:\n%s", body)
+	}
+}
+
+func TestMetrics_DisabledByDefault(t *testing.T) {
+	ts, _ := newBridgeServer(t, true)
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if strings.Contains(string(body), "phosphor_bridges_active") {
+		t.Error("/metrics served without EnableMetrics")
+	}
+}
+
+func TestMetrics_NilIsNoop(t *testing.T) {
+	var m *metrics
+	m.bridgeOpened()
+	m.bridgeClosed()
+	m.bridgeRefused("offline")
+	m.authFailed("api")
+	if c := m.countReads(nil, "upstream"); c != nil {
+		t.Error("countReads on nil metrics should return the conn unchanged")
+	}
+}
@@ -0,0 +1,120 @@
+package relay
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brporter/phosphor/internal/clock"
+)
+
+// AuthRateLimit throttles the unauthenticated /api/auth/* endpoints per
+// client IP with a token bucket. A zero Rate disables it.
+type AuthRateLimit struct {
+	Rate  float64 // sustained requests per second per IP
+	Burst int     // requests an idle IP may make at once; at least 1
+	// TrustProxy takes the client IP from the last X-Forwarded-For entry,
+	// for relays behind exactly one reverse proxy (e.g. Caddy). Without a
+	// proxy, leave it off: clients could otherwise pick their own bucket.
+	TrustProxy bool
+}
+
+// SetAuthRateLimit configures per-IP throttling of the auth endpoints.
+// Call it before Handler.
+func (s *Server) SetAuthRateLimit(limit AuthRateLimit) {
+	if limit.Rate <= 0 {
+		s.authLimiter = nil
+		return
+	}
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	s.authLimiter = &ipLimiter{limit: limit, buckets: make(map[string]*tokenBucket), clock: s.clock}
+}
+
+// ipLimiter keeps one token bucket per client IP.
+type ipLimiter struct {
+	limit AuthRateLimit
+	clock clock.Clock
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from ip's bucket. When the bucket is empty it reports
+// how long until the next token.
+func (l *ipLimiter) allow(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	burst := float64(l.limit.Burst)
+	l.sweep(now, burst)
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely, since a fresh bucket
+// would behave the same, so idle IPs don't accumulate. At most once a minute.
+func (l *ipLimiter) sweep(now time.Time, burst float64) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate >= burst {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+func (l *ipLimiter) clientIP(r *http.Request) string {
+	if l.limit.TrustProxy {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			parts := strings.Split(xff, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitAuth wraps an auth endpoint with the per-IP limiter, answering 429
+// with Retry-After once an IP's bucket is empty.
+func (s *Server) limitAuth(h http.HandlerFunc) http.Handler {
+	l := s.authLimiter
+	if l == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.allow(l.clientIP(r)); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSONError(w, http.StatusTooManyRequests, "rate limited")
+			return
+		}
+		h(w, r)
+	})
+}
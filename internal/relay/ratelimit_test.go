@@ -0,0 +1,101 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brporter/phosphor/internal/clock"
+)
+
+func pollFrom(h http.Handler, remote, xff string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/poll?session=nope", nil)
+	r.RemoteAddr = remote
+	if xff != "" {
+		r.Header.Set("X-Forwarded-For", xff)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAuthRateLimit_PerIP(t *testing.T) {
+	s := newTestServer(t)
+	s.SetAuthRateLimit(AuthRateLimit{Rate: 0.5, Burst: 3})
+	h := s.Handler()
+
+	for i := 0; i < 3; i++ {
+		if w := pollFrom(h, "192.0.2.1:1234", ""); w.Code == http.StatusTooManyRequests {
+			t.Fatalf("request %d limited inside the burst", i+1)
+		}
+	}
+	w := pollFrom(h, "192.0.2.1:5678", "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d past the burst, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+
+	// A different client has its own bucket.
+	if w := pollFrom(h, "192.0.2.2:1234", ""); w.Code == http.StatusTooManyRequests {
+		t.Error("second IP was limited by the first IP's traffic")
+	}
+	// Non-auth routes are never limited.
+	r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Errorf("/healthz status = %d, want 200", rec.Code)
+	}
+}
+
+func TestAuthRateLimit_Disabled(t *testing.T) {
+	s := newTestServer(t)
+	h := s.Handler()
+	for i := 0; i < 50; i++ {
+		if w := pollFrom(h, "192.0.2.1:1234", ""); w.Code == http.StatusTooManyRequests {
+			t.Fatal("limited without SetAuthRateLimit")
+		}
+	}
+}
+
+func TestAuthRateLimit_TrustProxy(t *testing.T) {
+	for _, trust := range []bool{false, true} {
+		s := newTestServer(t)
+		s.SetAuthRateLimit(AuthRateLimit{Rate: 1, Burst: 1, TrustProxy: trust})
+		h := s.Handler()
+
+		pollFrom(h, "10.0.0.1:1234", "spoofed, 198.51.100.1")
+		w := pollFrom(h, "10.0.0.1:1234", "spoofed, 198.51.100.2")
+		// Trusted: distinct forwarded clients behind one proxy each get a
+		// bucket. Untrusted: both share the proxy's address.
+		if limited := w.Code == http.StatusTooManyRequests; limited == trust {
+			t.Errorf("trust=%v: limited = %v", trust, limited)
+		}
+	}
+}
+
+func TestIPLimiter_Refills(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1000, 0))
+	l := &ipLimiter{limit: AuthRateLimit{Rate: 1, Burst: 2}, buckets: make(map[string]*tokenBucket), clock: clk}
+
+	l.allow("a")
+	l.allow("a")
+	if ok, wait := l.allow("a"); ok || wait != time.Second {
+		t.Fatalf("allow = %v, %v; want false, 1s", ok, wait)
+	}
+	clk.Advance(time.Second)
+	if ok, _ := l.allow("a"); !ok {
+		t.Fatal("not refilled after 1s")
+	}
+
+	// Idle, fully refilled buckets are swept.
+	clk.Advance(2 * time.Minute)
+	l.allow("b")
+	if _, ok := l.buckets["a"]; ok {
+		t.Error("idle bucket was not swept")
+	}
+}
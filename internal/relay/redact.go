@@ -0,0 +1,55 @@
+package relay
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// redactedValue replaces anything RedactSecrets masks.
+const redactedValue = "[REDACTED]"
+
+// sensitiveLogKeys are attribute keys whose values are always credentials.
+var sensitiveLogKeys = map[string]bool{
+	"token":         true,
+	"id_token":      true,
+	"access_token":  true,
+	"refresh_token": true,
+	"code":          true,
+	"code_verifier": true,
+	"client_secret": true,
+	"api_key":       true,
+	"authorization": true,
+}
+
+// secretPattern finds credentials embedded in free text, such as a query
+// string quoted in a *url.Error or an Authorization header echoed in a
+// provider's error: key=value pairs for the sensitive keys, and bearer
+// tokens.
+var secretPattern = regexp.MustCompile(`(?i)\b((?:id_token|access_token|refresh_token|token|code|code_verifier|client_secret|api_key)=)[^&\s"']+|\b(Bearer\s+)[^\s"']+`)
+
+// RedactSecrets is a slog.HandlerOptions.ReplaceAttr that keeps tokens,
+// authorization codes and client secrets out of relay logs. Values of
+// sensitive keys are masked outright. Secrets embedded in other string or
+// error values are masked in place, so a misconfiguration that echoes a
+// token into an error can't leak it either.
+func RedactSecrets(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveLogKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redactedValue)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if s := a.Value.String(); secretPattern.MatchString(s) {
+			return slog.String(a.Key, redactSecrets(s))
+		}
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok && secretPattern.MatchString(err.Error()) {
+			return slog.String(a.Key, redactSecrets(err.Error()))
+		}
+	}
+	return a
+}
+
+func redactSecrets(s string) string {
+	return secretPattern.ReplaceAllString(s, "${1}${2}"+redactedValue)
+}
@@ -0,0 +1,51 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{ReplaceAttr: RedactSecrets}))
+
+	logger.Info("auth",
+		"id_token", "eyJhbGciOi.payload.sig",
+		slog.Group("req", "code", "authcode123"),
+		"url", "https://relay/api/auth/callback?state=abc&code=authcode456",
+		"err", errors.New(`upstream said: Authorization: Bearer tok789`),
+		"machine", "m-1",
+	)
+	out := buf.String()
+
+	for _, secret := range []string{"eyJhbGciOi", "authcode123", "authcode456", "tok789"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log leaked %q:\n%s", secret, out)
+		}
+	}
+	for _, want := range []string{
+		"id_token=" + redactedValue,
+		"req.code=" + redactedValue,
+		"state=abc&code=" + redactedValue,
+		"Bearer " + redactedValue,
+		"machine=m-1",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRedactSecrets_LeavesOrdinaryValues(t *testing.T) {
+	a := slog.String("path", "/api/auth/poll")
+	if got := RedactSecrets(nil, a); !got.Equal(a) {
+		t.Errorf("RedactSecrets(%v) = %v", a, got)
+	}
+	e := slog.Any("err", errors.New("connection refused"))
+	if got := RedactSecrets(nil, e); !got.Equal(e) {
+		t.Errorf("RedactSecrets(%v) = %v", e, got)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/brporter/phosphor/internal/auth"
+	"github.com/brporter/phosphor/internal/clock"
 	"github.com/brporter/phosphor/internal/store"
 )
 
@@ -40,15 +42,38 @@ type Server struct {
 	db           DataStore
 
 	// SSH gateway wiring (SetSSHGate)
-	tunnels       TunnelDialer
-	sshPublicAddr string
-	sshHostKey    ssh.PublicKey
-	bridges       bridgeCounts
+	tunnels        TunnelDialer
+	sshPublicAddr  string
+	sshHostKey     ssh.PublicKey
+	bridges        bridgeCounts
+	bridgeLimits   BridgeLimits
+	allowedOrigins []string
+
+	metrics   *metrics // nil unless EnableMetrics is called
+	accessLog bool     // EnableAccessLog
+	staticDir string   // SetStaticDir
+
+	authLimiter *ipLimiter // nil unless SetAuthRateLimit enables it
+
+	expectedProviders []string    // SetExpectedProviders
+	shuttingDown      atomic.Bool // BeginShutdown
+
+	// closing is cancelled by CloseBridges. Hijacked WebSocket conns
+	// outlive http.Server.Shutdown, so bridges watch this instead.
+	closing     context.Context
+	closeBridge context.CancelFunc
+
+	clock clock.Clock // bridge timers and the auth rate limiter
 }
 
 // NewServer creates a new relay server.
 func NewServer(logger *slog.Logger, baseURL string, verifier *auth.Verifier, devMode bool, authSessions AuthSessionStoreI, apiKeySecret []byte, db DataStore) *Server {
-	return &Server{logger: logger, baseURL: baseURL, verifier: verifier, devMode: devMode, authSessions: authSessions, apiKeySecret: apiKeySecret, db: db}
+	if devMode {
+		logger.Warn("DEV MODE ENABLED: unsigned and provider:sub tokens are accepted; never run this configuration in production")
+	}
+	s := &Server{logger: logger, baseURL: baseURL, verifier: verifier, devMode: devMode, authSessions: authSessions, apiKeySecret: apiKeySecret, db: db, clock: clock.Real}
+	s.closing, s.closeBridge = context.WithCancel(context.Background())
+	return s
 }
 
 // Handler returns the HTTP handler with all routes.
@@ -65,28 +90,35 @@ func (s *Server) Handler() http.Handler {
 	mux.HandleFunc("DELETE /api/machines/{id}", s.HandleDeleteMachine)
 	mux.HandleFunc("GET /api/ssh-info", s.HandleSSHInfo)
 
-	// Auth flow endpoints
-	mux.HandleFunc("GET /api/auth/config", s.HandleAuthConfig)
-	mux.HandleFunc("POST /api/auth/login", s.HandleAuthLogin)
-	mux.HandleFunc("GET /api/auth/authorize", s.HandleAuthAuthorize)
-	mux.HandleFunc("GET /api/auth/callback", s.HandleAuthCallback)
-	mux.HandleFunc("POST /api/auth/callback", s.HandleAuthCallback)
-	mux.HandleFunc("GET /api/auth/poll", s.HandleAuthPoll)
-	mux.HandleFunc("POST /api/auth/api-key", s.HandleGenerateAPIKey)
+	// Auth flow endpoints (unauthenticated, so throttled per IP when
+	// SetAuthRateLimit is configured)
+	mux.Handle("GET /api/auth/config", s.limitAuth(s.HandleAuthConfig))
+	mux.Handle("POST /api/auth/login", s.limitAuth(s.HandleAuthLogin))
+	mux.Handle("GET /api/auth/authorize", s.limitAuth(s.HandleAuthAuthorize))
+	mux.Handle("GET /api/auth/callback", s.limitAuth(s.HandleAuthCallback))
+	mux.Handle("POST /api/auth/callback", s.limitAuth(s.HandleAuthCallback))
+	mux.Handle("GET /api/auth/poll", s.limitAuth(s.HandleAuthPoll))
+	mux.Handle("POST /api/auth/api-key", s.limitAuth(s.HandleGenerateAPIKey))
 
 	// CLI provider-picker auth flow
-	mux.HandleFunc("POST /api/auth/cli-start", s.HandleCLIStart)
-	mux.HandleFunc("GET /api/auth/cli-login", s.HandleCLILogin)
-	mux.HandleFunc("POST /api/auth/cli-choose", s.HandleCLIChoose)
+	mux.Handle("POST /api/auth/cli-start", s.limitAuth(s.HandleCLIStart))
+	mux.Handle("GET /api/auth/cli-login", s.limitAuth(s.HandleCLILogin))
+	mux.Handle("POST /api/auth/cli-choose", s.limitAuth(s.HandleCLIChoose))
+
+	// Health checks
+	mux.HandleFunc("GET /healthz", s.HandleHealthz)
+	mux.HandleFunc("GET /health", s.HandleHealthz)
+	mux.HandleFunc("GET /readyz", s.HandleReadyz)
 
-	// Health check
-	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
-	})
+	if s.metrics != nil {
+		mux.Handle("GET /metrics", s.metrics.handler())
+	}
 
 	// Static files (SPA) — served last as catch-all
 	mux.Handle("/", s.StaticHandler())
 
+	if s.accessLog {
+		return s.accessLogMiddleware(mux)
+	}
 	return mux
 }
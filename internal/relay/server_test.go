@@ -1,9 +1,12 @@
 package relay
 
 import (
+	"bytes"
+	"context"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -42,6 +45,19 @@ func TestNewServer(t *testing.T) {
 	}
 }
 
+func TestNewServer_WarnsInDevMode(t *testing.T) {
+	for _, devMode := range []bool{true, false} {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, nil))
+		NewServer(logger, "http://test", auth.NewVerifier(slog.Default()), devMode, nil, nil, dbstore.NewFake())
+
+		warned := strings.Contains(buf.String(), "level=WARN") && strings.Contains(buf.String(), "DEV MODE")
+		if warned != devMode {
+			t.Errorf("devMode=%v: warning logged = %v, log:\n%s", devMode, warned, buf.String())
+		}
+	}
+}
+
 func TestHandler_HealthEndpoint(t *testing.T) {
 	handler := newTestServer(t).Handler()
 
@@ -68,6 +84,8 @@ func TestHandler_RoutesExist(t *testing.T) {
 		{http.MethodGet, "/api/machines"},
 		{http.MethodGet, "/api/auth/poll"},
 		{http.MethodGet, "/health"},
+		{http.MethodGet, "/healthz"},
+		{http.MethodGet, "/readyz"},
 	}
 
 	for _, tc := range tests {
@@ -82,3 +100,66 @@ func TestHandler_RoutesExist(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_Readyz(t *testing.T) {
+	tests := []struct {
+		name      string
+		devMode   bool
+		providers []string // registered
+		expected  []string // SetExpectedProviders
+		gate      bool
+		shutdown  bool
+		wantCode  int
+		wantBody  string
+	}{
+		{"ready", false, []string{"google"}, []string{"google"}, true, false, http.StatusOK, "ok"},
+		{"dev mode needs no providers", true, nil, nil, true, false, http.StatusOK, "ok"},
+		{"no providers", false, nil, nil, true, false, http.StatusServiceUnavailable, "no identity providers registered"},
+		{"expected provider missing", false, []string{"google"}, []string{"google", "microsoft"}, true, false, http.StatusServiceUnavailable, "providers not registered: microsoft"},
+		{"no gateway", false, []string{"google"}, nil, false, false, http.StatusServiceUnavailable, "ssh gateway not configured"},
+		{"shutting down", false, []string{"google"}, nil, true, true, http.StatusServiceUnavailable, "shutting down"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			verifier := auth.NewVerifier(slog.Default())
+			for _, name := range tc.providers {
+				// UserInfo providers register without network discovery.
+				cfg := auth.ProviderConfig{
+					Name:        name,
+					ClientID:    "c",
+					AuthURL:     "https://idp.test/authorize",
+					TokenURL:    "https://idp.test/token",
+					UserInfoURL: "https://idp.test/user",
+					MapUserInfo: auth.MapGitHubUser,
+				}
+				if err := verifier.AddProvider(context.Background(), cfg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			authSessions := NewMemoryAuthSessionStore(5 * time.Minute)
+			t.Cleanup(authSessions.Stop)
+			s := NewServer(slog.Default(), "http://test", verifier, tc.devMode, authSessions, nil, dbstore.NewFake())
+			s.SetExpectedProviders(tc.expected)
+			if tc.gate {
+				s.SetSSHGate(&stubTunnels{}, "relay:2222", nil)
+			}
+			if tc.shutdown {
+				s.BeginShutdown()
+			}
+			handler := s.Handler()
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tc.wantCode || rec.Body.String() != tc.wantBody {
+				t.Errorf("readyz = %d %q, want %d %q", rec.Code, rec.Body.String(), tc.wantCode, tc.wantBody)
+			}
+
+			// Liveness is unaffected by readiness.
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("healthz = %d, want 200", rec.Code)
+			}
+		})
+	}
+}
@@ -7,8 +7,29 @@ import (
 	"strings"
 )
 
-// StaticHandler serves the embedded SPA. Falls back to index.html for client-side routing.
+// embeddedSPA is the bundled web UI when the relay is built with the
+// embedspa tag (see static_embed.go); nil otherwise.
+var embeddedSPA fs.FS
+
+// SetStaticDir sets a directory holding the built SPA, tried before the
+// embedded bundle and the default web/dist locations.
+func (s *Server) SetStaticDir(dir string) {
+	s.staticDir = dir
+}
+
+// StaticHandler serves the SPA. Falls back to index.html for client-side routing.
 func (s *Server) StaticHandler() http.Handler {
+	if s.staticDir != "" {
+		if _, err := os.Stat(s.staticDir); err == nil {
+			fsys := os.DirFS(s.staticDir)
+			return spaHandler(http.FileServerFS(fsys), fsys)
+		}
+		s.logger.Warn("static dir not found; falling back", "dir", s.staticDir)
+	}
+	if embeddedSPA != nil {
+		return spaHandler(http.FileServerFS(embeddedSPA), embeddedSPA)
+	}
+
 	// Check multiple possible locations for the built SPA
 	for _, distDir := range []string{"web/dist", "/web/dist"} {
 		if _, err := os.Stat(distDir); err == nil {
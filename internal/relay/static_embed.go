@@ -0,0 +1,17 @@
+//go:build embedspa
+
+package relay
+
+import (
+	"io/fs"
+
+	"github.com/brporter/phosphor/web"
+)
+
+func init() {
+	dist, err := fs.Sub(web.Dist, "dist")
+	if err != nil {
+		panic("embedded SPA: " + err.Error())
+	}
+	embeddedSPA = dist
+}
@@ -5,6 +5,8 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"testing/fstest"
@@ -78,6 +80,8 @@ func TestSpaHandler_Root(t *testing.T) {
 
 func TestStaticHandler_NoDistDir(t *testing.T) {
 	s := &Server{logger: slog.Default(), db: dbstore.NewFake()}
+	// A configured dir that doesn't exist falls through to the defaults.
+	s.SetStaticDir(filepath.Join(t.TempDir(), "missing"))
 
 	handler := s.StaticHandler()
 
@@ -95,3 +99,21 @@ func TestStaticHandler_NoDistDir(t *testing.T) {
 		t.Errorf("response body %q does not contain %q", string(body), "Web UI not built")
 	}
 }
+
+func TestStaticHandler_StaticDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>custom</html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{logger: slog.Default(), db: dbstore.NewFake()}
+	s.SetStaticDir(dir)
+	handler := s.StaticHandler()
+
+	for _, path := range []string{"/", "/machines/abc"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if !strings.Contains(w.Body.String(), "<html>custom</html>") {
+			t.Errorf("GET %s: body %q, want the STATIC_DIR index", path, w.Body.String())
+		}
+	}
+}
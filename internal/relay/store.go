@@ -22,5 +22,7 @@ type AuthSessionStoreI interface {
 	SetProvider(ctx context.Context, id, provider, codeVerifier string) error
 	Complete(ctx context.Context, id, idToken string) error
 	Consume(ctx context.Context, id string) (string, bool, error)
+	// TTL is how long a session stays valid after Create.
+	TTL() time.Duration
 	Stop()
 }
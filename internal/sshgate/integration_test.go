@@ -54,6 +54,13 @@ type testEnv struct {
 }
 
 func startGateway(t *testing.T) *testEnv {
+	t.Helper()
+	return startGatewayWith(t, func(*sshgate.Server) {})
+}
+
+// startGatewayWith is startGateway with a hook to configure the server
+// before it starts listening.
+func startGatewayWith(t *testing.T, configure func(*sshgate.Server)) *testEnv {
 	t.Helper()
 	ctx, cancel := context.WithCancel(context.Background())
 	t.Cleanup(cancel)
@@ -88,6 +95,7 @@ func startGateway(t *testing.T) *testEnv {
 
 	registry := sshgate.NewRegistry()
 	gate := sshgate.NewServer(registry, db, hostSigner, slog.Default())
+	configure(gate)
 
 	started := make(chan struct{})
 	go func() {
@@ -193,6 +201,12 @@ func TestTunnelReconnect(t *testing.T) {
 		t.Fatal("expected an active tunnel to close")
 	}
 	waitOnline(t, env, true, 10*time.Second)
+	if got := env.registry.Connects(); got < 2 {
+		t.Errorf("Connects() = %d after reconnect, want >= 2", got)
+	}
+	if got := env.registry.Count(); got != 1 {
+		t.Errorf("Count() = %d, want 1", got)
+	}
 
 	conn, err := env.registry.Dial(env.machineID)
 	if err != nil {
@@ -252,3 +266,75 @@ func TestDialOffline(t *testing.T) {
 		t.Fatal("expected error dialing offline machine")
 	}
 }
+
+// TestKeepaliveDropsWedgedCLI registers a tunnel from a client that never
+// services incoming requests, like a hung CLI whose socket is still open,
+// and expects the gateway to drop it.
+func TestKeepaliveDropsWedgedCLI(t *testing.T) {
+	env := startGatewayWith(t, func(g *sshgate.Server) {
+		g.SetKeepalive(50*time.Millisecond, 100*time.Millisecond)
+	})
+
+	nc, err := net.Dial("tcp", env.gateAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	conn, _, _, err := ssh.NewClientConn(nc, env.gateAddr, &ssh.ClientConfig{
+		User:            env.machineID,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(env.signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The global request channel is deliberately never drained, so the
+	// gateway's keepalives go unanswered.
+	payload := ssh.Marshal(struct {
+		Addr string
+		Port uint32
+	}{"localhost", 22})
+	if ok, _, err := conn.SendRequest("tcpip-forward", true, payload); err != nil || !ok {
+		t.Fatalf("tcpip-forward: ok=%v err=%v", ok, err)
+	}
+
+	waitOnline(t, env, true, 2*time.Second)
+	waitOnline(t, env, false, 3*time.Second)
+}
+
+func TestKeepaliveKeepsHealthyCLI(t *testing.T) {
+	env := startGatewayWith(t, func(g *sshgate.Server) {
+		g.SetKeepalive(20*time.Millisecond, time.Second)
+	})
+	stop := startTunnel(t, env, startEchoServer(t))
+	defer stop()
+
+	waitOnline(t, env, true, 5*time.Second)
+	time.Sleep(200 * time.Millisecond)
+	if !env.registry.Online(env.machineID) {
+		t.Fatal("healthy tunnel dropped by keepalive")
+	}
+	if n := env.registry.Connects(); n != 1 {
+		t.Errorf("Connects = %d, want 1 (no reconnects)", n)
+	}
+}
+
+// TestKeepaliveZeroTimeoutUsesDefault guards against a zero timeout racing
+// every reply and dropping healthy tunnels on each probe.
+func TestKeepaliveZeroTimeoutUsesDefault(t *testing.T) {
+	env := startGatewayWith(t, func(g *sshgate.Server) {
+		g.SetKeepalive(20*time.Millisecond, 0)
+	})
+	stop := startTunnel(t, env, startEchoServer(t))
+	defer stop()
+
+	waitOnline(t, env, true, 5*time.Second)
+	time.Sleep(200 * time.Millisecond)
+	if !env.registry.Online(env.machineID) {
+		t.Fatal("healthy tunnel dropped with a zero keepalive timeout")
+	}
+	if n := env.registry.Connects(); n != 1 {
+		t.Errorf("Connects = %d, want 1 (no reconnects)", n)
+	}
+}
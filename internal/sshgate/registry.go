@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -27,8 +28,9 @@ type Tunnel struct {
 
 // Registry tracks which machines currently have live tunnels.
 type Registry struct {
-	mu      sync.RWMutex
-	tunnels map[string]*Tunnel
+	mu       sync.RWMutex
+	tunnels  map[string]*Tunnel
+	connects atomic.Uint64
 }
 
 func NewRegistry() *Registry {
@@ -42,6 +44,7 @@ func (r *Registry) register(t *Tunnel) {
 	old := r.tunnels[t.MachineID]
 	r.tunnels[t.MachineID] = t
 	r.mu.Unlock()
+	r.connects.Add(1)
 	if old != nil && old.conn != t.conn {
 		old.conn.Close()
 	}
@@ -65,6 +68,19 @@ func (r *Registry) Online(machineID string) bool {
 	return ok
 }
 
+// Count reports how many machines currently have a tunnel.
+func (r *Registry) Count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.tunnels)
+}
+
+// Connects reports how many tunnels have been registered since start,
+// reconnects included.
+func (r *Registry) Connects() uint64 {
+	return r.connects.Load()
+}
+
 // Close terminates a machine's tunnel if one exists.
 func (r *Registry) Close(machineID string) bool {
 	r.mu.Lock()
@@ -24,6 +24,11 @@ import (
 // every 30s, so a healthy tunnel never trips this).
 const idleTimeout = 90 * time.Second
 
+const (
+	defaultKeepaliveInterval = 30 * time.Second
+	defaultKeepaliveTimeout  = 15 * time.Second
+)
+
 // MachineStore is the store surface the gateway needs.
 type MachineStore interface {
 	GetMachineByFingerprint(ctx context.Context, fingerprint string) (*store.Machine, error)
@@ -38,6 +43,9 @@ type Server struct {
 	cfg      *ssh.ServerConfig
 	hostKey  ssh.Signer
 
+	keepaliveInterval time.Duration
+	keepaliveTimeout  time.Duration
+
 	mu       sync.Mutex
 	listener net.Listener
 }
@@ -47,7 +55,14 @@ type Server struct {
 // lookup credential and the SSH username must match the machine ID it maps
 // to.
 func NewServer(registry *Registry, db MachineStore, hostKey ssh.Signer, logger *slog.Logger) *Server {
-	s := &Server{registry: registry, db: db, logger: logger, hostKey: hostKey}
+	s := &Server{
+		registry:          registry,
+		db:                db,
+		logger:            logger,
+		hostKey:           hostKey,
+		keepaliveInterval: defaultKeepaliveInterval,
+		keepaliveTimeout:  defaultKeepaliveTimeout,
+	}
 	s.cfg = &ssh.ServerConfig{
 		ServerVersion: "SSH-2.0-Phosphor",
 		MaxAuthTries:  3,
@@ -70,6 +85,20 @@ func NewServer(registry *Registry, db MachineStore, hostKey ssh.Signer, logger *
 	return s
 }
 
+// SetKeepalive sets how often the gateway probes each CLI and how long it
+// waits for a reply before dropping the tunnel. TCP alone can't tell a
+// wedged CLI from an idle one; any reply, even a refusal, proves the
+// process is still servicing its connection. A non-positive interval
+// disables the probes; a non-positive timeout means the default. Call it
+// before ListenAndServe.
+func (s *Server) SetKeepalive(interval, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultKeepaliveTimeout
+	}
+	s.keepaliveInterval = interval
+	s.keepaliveTimeout = timeout
+}
+
 // HostPublicKey returns the gateway's public host key for out-of-band
 // pinning by CLIs (served over TLS at /api/ssh-info).
 func (s *Server) HostPublicKey() ssh.PublicKey {
@@ -191,10 +220,49 @@ func (s *Server) handleConn(ctx context.Context, nc net.Conn) {
 		}
 	}()
 
+	closed := make(chan struct{})
 	go func() {
 		conn.Wait()
 		nc.Close()
+		close(closed)
 	}()
+
+	if s.keepaliveInterval > 0 {
+		go s.keepalive(conn, closed, logger)
+	}
+}
+
+// keepalive probes conn until it closes, closing it on the first probe that
+// fails or goes unanswered for keepaliveTimeout.
+func (s *Server) keepalive(conn *ssh.ServerConn, closed <-chan struct{}, logger *slog.Logger) {
+	ticker := time.NewTicker(s.keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ticker.C:
+			done := make(chan error, 1)
+			go func() {
+				_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err != nil {
+					logger.Debug("keepalive failed", "err", err)
+					conn.Close()
+					return
+				}
+			case <-time.After(s.keepaliveTimeout):
+				logger.Info("keepalive timed out; dropping tunnel")
+				conn.Close()
+				return
+			case <-closed:
+				return
+			}
+		}
+	}
 }
 
 // idleConn extends a read deadline on every successful read or write, so a
@@ -23,6 +23,9 @@ type connectOptions struct {
 	rows, cols int
 }
 
+// subprotocol is the WebSocket subprotocol the relay's SSH bridge speaks.
+const subprotocol = "phosphor-ssh"
+
 // session wraps a live SSH session and exposes write/resize/disconnect to JS.
 type session struct {
 	client  *ssh.Client
@@ -36,7 +39,7 @@ type session struct {
 // connect performs the SSH handshake against the host through the relay
 // tunnel and starts an interactive shell. It returns a JS handle object.
 func connect(opts connectOptions) (js.Value, error) {
-	ws := js.Global().Get("WebSocket").New(opts.wsURL, "phosphor-ssh")
+	ws := js.Global().Get("WebSocket").New(opts.wsURL, subprotocol)
 	ws.Set("binaryType", "arraybuffer")
 
 	// Wait for the socket to open, then send the auth prelude and wait for
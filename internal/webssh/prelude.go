@@ -60,19 +60,29 @@ func openAndAuth(ws js.Value, token string) error {
 		release()
 	}()
 
-	// If the socket is already open, send immediately.
-	sendAuth := func() {
+	// If the socket is already open, send immediately. Browsers accept a
+	// server that picks no subprotocol, so confirm the relay agreed to ours
+	// before sending the token.
+	sendAuth := func() error {
+		if p := ws.Get("protocol").String(); p != subprotocol {
+			return errors.New("relay did not negotiate the " + subprotocol + " subprotocol")
+		}
 		payload, _ := json.Marshal(map[string]string{"token": token})
 		ws.Call("send", string(payload))
+		return nil
 	}
 	if ws.Get("readyState").Int() == 1 { // OPEN
-		sendAuth()
+		if err := sendAuth(); err != nil {
+			return err
+		}
 	}
 
 	for e := range ch {
 		switch e.kind {
 		case "open":
-			sendAuth()
+			if err := sendAuth(); err != nil {
+				return err
+			}
 		case "message":
 			var ack struct {
 				OK    bool   `json:"ok"`
@@ -0,0 +1,10 @@
+//go:build embedspa
+
+// Package web bundles the built SPA (web/dist) into the relay binary when it
+// is built with -tags embedspa. Run `make web` first so dist exists.
+package web
+
+import "embed"
+
+//go:embed all:dist
+var Dist embed.FS
@@ -23,6 +23,7 @@ interface AuthContextValue {
   user: AuthUser | null;
   isLoading: boolean;
   providers: string[];
+  devMode: boolean;
   login: (provider: string) => Promise<void>;
   logout: () => Promise<void>;
   getToken: () => string | null;
@@ -32,6 +33,7 @@ export const AuthContext = createContext<AuthContextValue>({
   user: null,
   isLoading: true,
   providers: [],
+  devMode: false,
   login: async () => {},
   logout: async () => {},
   getToken: () => null,
@@ -42,6 +44,10 @@ const SESSION_KEY = "phosphor_auth_session";
 
 function parseJwtPayload(token: string): UserProfile {
   const parts = token.split(".");
+  if (parts.length !== 3) {
+    // Opaque access token (e.g. GitHub): there are no claims to read locally.
+    return { sub: "", iss: "" };
+  }
   const payload = JSON.parse(atob(parts[1] ?? ""));
   return { sub: payload.sub, iss: payload.iss, email: payload.email };
 }
@@ -55,8 +61,9 @@ function loadUser(): AuthUser | null {
   if (!raw) return null;
   try {
     const user = JSON.parse(raw) as AuthUser;
-    // Check token expiry
+    // Check token expiry; opaque tokens carry none and are checked by the relay.
     const parts = user.id_token.split(".");
+    if (parts.length !== 3) return user;
     const payload = JSON.parse(atob(parts[1] ?? ""));
     if (payload.exp && payload.exp * 1000 < Date.now()) {
       localStorage.removeItem(STORAGE_KEY);
@@ -73,10 +80,14 @@ export function AuthProvider({ children }: { children: ReactNode }) {
   const [user, setUser] = useState<AuthUser | null>(null);
   const [isLoading, setIsLoading] = useState(true);
   const [providers, setProviders] = useState<string[]>([]);
+  const [devMode, setDevMode] = useState(false);
 
   useEffect(() => {
     // Fetch available providers from the relay.
-    fetchAuthConfig().then((cfg) => setProviders(cfg.providers));
+    fetchAuthConfig().then((cfg) => {
+      setProviders(cfg.providers);
+      setDevMode(cfg.dev_mode ?? false);
+    });
 
     // Check for pending auth session (returning from provider redirect)
     const sessionId = localStorage.getItem(SESSION_KEY);
@@ -147,8 +158,8 @@ export function AuthProvider({ children }: { children: ReactNode }) {
   }, [user]);
 
   const value = useMemo(
-    () => ({ user, isLoading, providers, login, logout, getToken }),
-    [user, isLoading, providers, login, logout, getToken],
+    () => ({ user, isLoading, providers, devMode, login, logout, getToken }),
+    [user, isLoading, providers, devMode, login, logout, getToken],
   );
 
   return <AuthContext.Provider value={value}>{children}</AuthContext.Provider>;
@@ -13,6 +13,7 @@ function renderLayout(authOverrides = {}) {
     user: null,
     isLoading: false,
     providers: ["microsoft", "google", "apple"],
+    devMode: false,
     login: vi.fn(),
     logout: vi.fn(),
     getToken: vi.fn(() => null),
@@ -49,6 +50,18 @@ describe("Layout", () => {
     expect(screen.queryByText("[sign in with Microsoft]")).not.toBeInTheDocument();
   });
 
+  it("warns when the relay is in dev mode", () => {
+    renderLayout({ devMode: true });
+
+    expect(screen.getByRole("alert")).toHaveTextContent(/dev mode/);
+  });
+
+  it("shows no dev-mode warning otherwise", () => {
+    renderLayout();
+
+    expect(screen.queryByRole("alert")).not.toBeInTheDocument();
+  });
+
   it("renders footer", () => {
     renderLayout();
 
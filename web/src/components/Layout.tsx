@@ -3,7 +3,7 @@ import { useAuth } from "../auth/useAuth";
 import { ProviderButtons } from "./ProviderButtons";
 
 export function Layout() {
-  const { user, providers, login, logout } = useAuth();
+  const { user, providers, devMode, login, logout } = useAuth();
 
   return (
     <div
@@ -50,7 +50,7 @@ export function Layout() {
           {user ? (
             <>
               <span style={{ color: "#00aa33", fontSize: 12 }}>
-                {user.profile?.email ?? user.profile?.sub ?? "signed in"}
+                {user.profile?.email || user.profile?.sub || "signed in"}
               </span>
               <Link
                 to="/keys"
@@ -74,6 +74,23 @@ export function Layout() {
         </div>
       </header>
 
+      {devMode && (
+        <div
+          role="alert"
+          style={{
+            padding: "4px 16px",
+            background: "#332b00",
+            borderBottom: "1px solid #aa8800",
+            color: "#ffcc00",
+            fontSize: 12,
+            textAlign: "center",
+            flexShrink: 0,
+          }}
+        >
+          warning: this relay is running in dev mode — authentication is not enforced
+        </div>
+      )}
+
       {/* Main content */}
       <main style={{ flex: 1, overflow: "auto", padding: 16 }}>
         <Outlet />
@@ -13,6 +13,7 @@ function renderWithAuth(ui: React.ReactElement, authOverrides = {}) {
     user: null,
     isLoading: false,
     providers: ["microsoft", "google", "apple"],
+    devMode: false,
     login: vi.fn(),
     logout: vi.fn(),
     getToken: vi.fn(() => null),
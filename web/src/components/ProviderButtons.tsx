@@ -2,6 +2,7 @@ const PROVIDER_LABELS: Record<string, string> = {
   microsoft: "[sign in with Microsoft]",
   google: "[sign in with Google]",
   apple: "[sign in with Apple]",
+  github: "[sign in with GitHub]",
   dev: "[dev mode]",
 };
 
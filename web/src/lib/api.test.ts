@@ -24,6 +24,17 @@ describe('fetchAuthConfig', () => {
     expect(mockFetch).toHaveBeenCalledWith('/api/auth/config');
   });
 
+  it('passes through the dev_mode flag', async () => {
+    mockFetch.mockResolvedValueOnce({
+      ok: true,
+      json: () => Promise.resolve({ providers: ['dev'], dev_mode: true }),
+    });
+
+    const result = await fetchAuthConfig();
+
+    expect(result.dev_mode).toBe(true);
+  });
+
   it('returns an empty provider list on non-ok response', async () => {
     mockFetch.mockResolvedValueOnce({ ok: false, status: 500 });
 
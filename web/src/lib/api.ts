@@ -2,6 +2,7 @@ const BASE = "";
 
 export interface AuthConfig {
   providers: string[];
+  dev_mode?: boolean;
 }
 
 export async function fetchAuthConfig(): Promise<AuthConfig> {